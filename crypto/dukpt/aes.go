@@ -0,0 +1,109 @@
+package dukpt
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+)
+
+// key usage indicators of X9.24-3 derivation data
+const (
+	usageMACGeneration   = 0x2000
+	usageMACVerification = 0x2001
+	usagePIN             = 0x1000
+	usageDataEncrypt     = 0x3000
+	usageDataDecrypt     = 0x3001
+	usageKeyDerivation   = 0x8000
+	usageInitialKey      = 0x8001
+)
+
+// aesAlgorithm return X9.24-3 algorithm indicator for AES key length
+func aesAlgorithm(keyLen int) (uint16, error) {
+	switch keyLen {
+	case 16:
+		return 2, nil
+	case 24:
+		return 3, nil
+	case 32:
+		return 4, nil
+	default:
+		return 0, ErrInvalidKey
+	}
+}
+
+// derivationData create X9.24-3 derivation data, the key block counter (byte 1) is set by aesDeriveKey
+func derivationData(usage uint16, keyLen int, initialKeyID []byte, counter uint32, initial bool) []byte {
+	alg, _ := aesAlgorithm(keyLen)
+
+	data := make([]byte, 16)
+	data[0] = 0x01
+	binary.BigEndian.PutUint16(data[2:], usage)
+	binary.BigEndian.PutUint16(data[4:], alg)
+	binary.BigEndian.PutUint16(data[6:], uint16(keyLen*8))
+	if initial {
+		copy(data[8:], initialKeyID[:8])
+	} else {
+		copy(data[8:], initialKeyID[4:8])
+		binary.BigEndian.PutUint32(data[12:], counter)
+	}
+	return data
+}
+
+// aesDeriveKey derive key with keyLen bytes from derivation key, NIST SP 800-108 counter mode with AES-ECB as PRF
+func aesDeriveKey(key []byte, keyLen int, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]byte, (keyLen+15)/16*16)
+	for i := 0; i*16 < keyLen; i++ {
+		data[1] = byte(i + 1)
+		block.Encrypt(ret[i*16:], data)
+	}
+	return ret[:keyLen], nil
+}
+
+func aesInitialKey(bdk, initialKeyID []byte) ([]byte, error) {
+	return aesDeriveKey(bdk, len(bdk), derivationData(usageInitialKey, len(bdk), initialKeyID, 0, true))
+}
+
+func aesTransactionKeys(ik, ksn []byte) (keys.Static, error) {
+	counter, _ := Counter(ksn)
+	if counter == 0 || bits.OnesCount32(counter) > 16 {
+		return nil, ErrInvalidKSN
+	}
+	initialKeyID := ksn[:8]
+
+	key := ik
+	var reg uint32
+	for bit := uint32(1 << 31); bit > 0; bit >>= 1 {
+		if counter&bit == 0 {
+			continue
+		}
+		reg |= bit
+		var err error
+		key, err = aesDeriveKey(key, len(key), derivationData(usageKeyDerivation, len(key), initialKeyID, reg, false))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ret := keys.Static{}
+	for name, usage := range map[string]uint16{
+		KeyPIN:          usagePIN,
+		KeyMACRequest:   usageMACGeneration,
+		KeyMACResponse:  usageMACVerification,
+		KeyDataRequest:  usageDataEncrypt,
+		KeyDataResponse: usageDataDecrypt,
+	} {
+		value, err := aesDeriveKey(key, len(key), derivationData(usage, len(key), initialKeyID, counter, false))
+		if err != nil {
+			return nil, err
+		}
+		ret[name] = keys.Key{Type: keys.AES, Value: value}
+	}
+	return ret, nil
+}
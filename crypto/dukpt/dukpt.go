@@ -0,0 +1,194 @@
+// Package dukpt implement host side ANSI X9.24 DUKPT key derivation,
+// X9.24-1 (TDES, 10 bytes KSN) and X9.24-3 (AES, 12 bytes KSN).
+//
+// The derived transaction keys are returned as keys.Static,
+// so they can be used directly by the pinblock and mac packages.
+package dukpt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+)
+
+// Key names in keys.Static returned by TransactionKeys and Derive.
+const (
+	KeyPIN          = "pin"
+	KeyMACRequest   = "mac-request"
+	KeyMACResponse  = "mac-response"
+	KeyDataRequest  = "data-request"
+	KeyDataResponse = "data-response"
+)
+
+// ErrInvalidKSN .
+var ErrInvalidKSN = fmt.Errorf("invalid KSN")
+
+// ErrInvalidKey .
+var ErrInvalidKey = fmt.Errorf("invalid DUKPT key")
+
+// Derive derive the transaction keys of ksn from bdk, see InitialKey and TransactionKeys.
+func Derive(bdk keys.Key, ksn []byte) (keys.Static, error) {
+	ik, err := InitialKey(bdk, ksn)
+	if err != nil {
+		return nil, err
+	}
+	return TransactionKeys(ik, ksn)
+}
+
+// InitialKey derive the initial key (IPEK for TDES) of ksn from bdk.
+// TDES bdk must be double length key and ksn must be 10 bytes,
+// AES bdk can be 128, 192 or 256 bits and ksn must be 12 bytes.
+func InitialKey(bdk keys.Key, ksn []byte) (keys.Key, error) {
+	switch bdk.Type {
+	case keys.TDES:
+		if len(bdk.Value) != 16 {
+			return keys.Key{}, ErrInvalidKey
+		}
+		if len(ksn) != 10 {
+			return keys.Key{}, ErrInvalidKSN
+		}
+		return keys.Key{Type: keys.TDES, Value: tdesIPEK(bdk.Value, ksn)}, nil
+	case keys.AES:
+		if _, err := aesAlgorithm(len(bdk.Value)); err != nil {
+			return keys.Key{}, err
+		}
+		if len(ksn) != 12 {
+			return keys.Key{}, ErrInvalidKSN
+		}
+		value, err := aesInitialKey(bdk.Value, ksn[:8])
+		if err != nil {
+			return keys.Key{}, err
+		}
+		return keys.Key{Type: keys.AES, Value: value}, nil
+	default:
+		return keys.Key{}, ErrInvalidKey
+	}
+}
+
+// TransactionKeys derive the PIN, MAC and data encryption keys of ksn from the initial key.
+//
+// Request keys are used for message from the terminal and response keys are used for message to the terminal.
+func TransactionKeys(ik keys.Key, ksn []byte) (keys.Static, error) {
+	switch ik.Type {
+	case keys.TDES:
+		if len(ik.Value) != 16 {
+			return nil, ErrInvalidKey
+		}
+		if len(ksn) != 10 {
+			return nil, ErrInvalidKSN
+		}
+		return tdesTransactionKeys(ik.Value, ksn)
+	case keys.AES:
+		if _, err := aesAlgorithm(len(ik.Value)); err != nil {
+			return nil, err
+		}
+		if len(ksn) != 12 {
+			return nil, ErrInvalidKSN
+		}
+		return aesTransactionKeys(ik.Value, ksn)
+	default:
+		return nil, ErrInvalidKey
+	}
+}
+
+// Counter return the transaction counter of ksn.
+func Counter(ksn []byte) (uint32, error) {
+	switch len(ksn) {
+	case 10:
+		return uint32(ksn[7]&0x1F)<<16 | uint32(ksn[8])<<8 | uint32(ksn[9]), nil
+	case 12:
+		return binary.BigEndian.Uint32(ksn[8:]), nil
+	default:
+		return 0, ErrInvalidKSN
+	}
+}
+
+var keyVariantMask = []byte{0xC0, 0xC0, 0xC0, 0xC0, 0x00, 0x00, 0x00, 0x00, 0xC0, 0xC0, 0xC0, 0xC0, 0x00, 0x00, 0x00, 0x00}
+
+// variants of X9.24-1 future key, see tdesTransactionKeys
+var (
+	variantPIN          = variant(7)
+	variantMACRequest   = variant(6)
+	variantMACResponse  = variant(4)
+	variantDataRequest  = variant(5)
+	variantDataResponse = variant(3)
+)
+
+func variant(i int) []byte {
+	v := make([]byte, 16)
+	v[i] = 0xFF
+	v[8+i] = 0xFF
+	return v
+}
+
+func tdesIPEK(bdk, ksn []byte) []byte {
+	data := make([]byte, 8)
+	copy(data, ksn[:8])
+	data[7] &= 0xE0
+
+	left := tdesEncrypt(bdk, data)
+	right := tdesEncrypt(xor(bdk, keyVariantMask), data)
+	return append(left, right...)
+}
+
+func tdesTransactionKeys(ipek, ksn []byte) (keys.Static, error) {
+	counter, _ := Counter(ksn)
+	if counter == 0 || bits.OnesCount32(counter) > 10 {
+		return nil, ErrInvalidKSN
+	}
+
+	// rightmost 64 bits of the KSN with the 21 bits counter cleared
+	reg := binary.BigEndian.Uint64(ksn[2:]) &^ 0x1FFFFF
+
+	key := ipek
+	for bit := uint32(1 << 20); bit > 0; bit >>= 1 {
+		if counter&bit == 0 {
+			continue
+		}
+		reg |= uint64(bit)
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, reg)
+		key = nonReversible(key, data)
+	}
+
+	dataRequest := xor(key, variantDataRequest)
+	dataResponse := xor(key, variantDataResponse)
+	return keys.Static{
+		KeyPIN:          {Type: keys.TDES, Value: xor(key, variantPIN)},
+		KeyMACRequest:   {Type: keys.TDES, Value: xor(key, variantMACRequest)},
+		KeyMACResponse:  {Type: keys.TDES, Value: xor(key, variantMACResponse)},
+		KeyDataRequest:  {Type: keys.TDES, Value: append(tdesEncrypt(dataRequest, dataRequest[:8]), tdesEncrypt(dataRequest, dataRequest[8:])...)},
+		KeyDataResponse: {Type: keys.TDES, Value: append(tdesEncrypt(dataResponse, dataResponse[:8]), tdesEncrypt(dataResponse, dataResponse[8:])...)},
+	}, nil
+}
+
+// nonReversible is the non-reversible key generation process of X9.24-1
+func nonReversible(key, data []byte) []byte {
+	half := func(k []byte) []byte {
+		ret := xor(data, k[8:])
+		ret = tdesEncrypt(k[:8], ret)
+		return xor(ret, k[8:])
+	}
+	right := half(key)
+	left := half(xor(key, keyVariantMask))
+	return append(left, right...)
+}
+
+// tdesEncrypt encrypt one block with single or double length key, the length is always valid here
+func tdesEncrypt(key, data []byte) []byte {
+	ret, err := keys.Key{Type: keys.TDES, Value: key}.EncryptECB(data)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+func xor(a, b []byte) []byte {
+	ret := make([]byte, len(a))
+	for i := range ret {
+		ret[i] = a[i] ^ b[i]
+	}
+	return ret
+}
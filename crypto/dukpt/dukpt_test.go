@@ -0,0 +1,188 @@
+package dukpt_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/dukpt"
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+	"github.com/payfazz/iso8585-utility-lib/crypto/mac"
+	"github.com/payfazz/iso8585-utility-lib/crypto/pinblock"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func toHex(b []byte) string {
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+var tdesBDK = keys.Key{Type: keys.TDES, Value: mustHex("0123456789ABCDEFFEDCBA9876543210")}
+
+var aesBDK = keys.Key{Type: keys.AES, Value: mustHex("FEDCBA9876543210F1F1F1F1F1F1F1F1")}
+
+// test vectors from X9.24-1
+func TestTDES(t *testing.T) {
+	ipek, err := dukpt.InitialKey(tdesBDK, mustHex("FFFF9876543210E00000"))
+	if err != nil {
+		t.Fatalf("invalid InitialKey: %s", err.Error())
+	}
+	if got := toHex(ipek.Value); got != "6AC292FAA1315B4D858AB3A3D7D5933A" {
+		t.Fatalf("invalid IPEK: %s", got)
+	}
+
+	ks, err := dukpt.TransactionKeys(ipek, mustHex("FFFF9876543210E00001"))
+	if err != nil {
+		t.Fatalf("invalid TransactionKeys: %s", err.Error())
+	}
+	if got := toHex(ks[dukpt.KeyPIN].Value); got != "042666B49184CF5C68DE9628D0397B36" {
+		t.Fatalf("invalid PIN key: %s", got)
+	}
+	if got := toHex(ks[dukpt.KeyMACRequest].Value); got != "042666B4918430A368DE9628D03984C9" {
+		t.Fatalf("invalid MAC request key: %s", got)
+	}
+
+	// PIN 1234, PAN 4012345678909, format 0
+	for ksn, expected := range map[string]string{
+		"FFFF9876543210E00001": "1B9C1845EB993A7A",
+		"FFFF9876543210E00002": "10A01C8D02C69107",
+		"FFFF9876543210E00003": "18DC07B94797B466",
+	} {
+		ks, err := dukpt.Derive(tdesBDK, mustHex(ksn))
+		if err != nil {
+			t.Fatalf("invalid Derive: %s", err.Error())
+		}
+		clear, err := pinblock.Clear(pinblock.Format0, "1234", "4012345678909")
+		if err != nil {
+			t.Fatalf("invalid Clear: %s", err.Error())
+		}
+		block, err := ks.EncryptBlock(dukpt.KeyPIN, clear)
+		if err != nil {
+			t.Fatalf("invalid EncryptBlock: %s", err.Error())
+		}
+		if got := toHex(block); got != expected {
+			t.Fatalf("invalid PIN block for KSN %s: %s", ksn, got)
+		}
+		if pin, err := pinblock.Decrypt(ks, dukpt.KeyPIN, pinblock.Format0, block, "4012345678909"); err != nil || pin != "1234" {
+			t.Fatalf("invalid Decrypt for KSN %s: %q %v", ksn, pin, err)
+		}
+	}
+}
+
+// test vectors from X9.24-3
+func TestAES(t *testing.T) {
+	ksn := mustHex("123456789012345600000001")
+
+	ik, err := dukpt.InitialKey(aesBDK, ksn)
+	if err != nil {
+		t.Fatalf("invalid InitialKey: %s", err.Error())
+	}
+	if got := toHex(ik.Value); got != "1273671EA26AC29AFA4D1084127652A1" {
+		t.Fatalf("invalid initial key: %s", got)
+	}
+
+	ks, err := dukpt.Derive(aesBDK, ksn)
+	if err != nil {
+		t.Fatalf("invalid Derive: %s", err.Error())
+	}
+	if got := toHex(ks[dukpt.KeyPIN].Value); got != "AF8CB133A78F8DC2D1359F18527593FB" {
+		t.Fatalf("invalid PIN key: %s", got)
+	}
+
+	block, err := pinblock.Encrypt(ks, dukpt.KeyPIN, pinblock.Format4, "1234", "4111111111111111")
+	if err != nil {
+		t.Fatalf("invalid Encrypt: %s", err.Error())
+	}
+	if pin, err := pinblock.Decrypt(ks, dukpt.KeyPIN, pinblock.Format4, block, "4111111111111111"); err != nil || pin != "1234" {
+		t.Fatalf("invalid Decrypt: %q %v", pin, err)
+	}
+
+	for _, bdk := range []keys.Key{
+		{Type: keys.AES, Value: bytes.Repeat([]byte{0x11}, 24)},
+		{Type: keys.AES, Value: bytes.Repeat([]byte{0x11}, 32)},
+	} {
+		ks, err := dukpt.Derive(bdk, ksn)
+		if err != nil {
+			t.Fatalf("invalid Derive with %d bytes BDK: %s", len(bdk.Value), err.Error())
+		}
+		if len(ks[dukpt.KeyDataRequest].Value) != len(bdk.Value) {
+			t.Fatalf("invalid working key length for %d bytes BDK", len(bdk.Value))
+		}
+	}
+}
+
+func TestKeysAreDistinct(t *testing.T) {
+	for _, c := range []struct {
+		bdk keys.Key
+		ksn string
+	}{
+		{tdesBDK, "FFFF9876543210E00005"},
+		{aesBDK, "123456789012345600000005"},
+	} {
+		ks, err := dukpt.Derive(c.bdk, mustHex(c.ksn))
+		if err != nil {
+			t.Fatalf("invalid Derive: %s", err.Error())
+		}
+		seen := map[string]string{}
+		for _, name := range []string{dukpt.KeyPIN, dukpt.KeyMACRequest, dukpt.KeyMACResponse, dukpt.KeyDataRequest, dukpt.KeyDataResponse} {
+			k, ok := ks[name]
+			if !ok {
+				t.Fatalf("missing %s key", name)
+			}
+			if other, ok := seen[toHex(k.Value)]; ok {
+				t.Fatalf("%s key is equal to %s key", name, other)
+			}
+			seen[toHex(k.Value)] = name
+		}
+	}
+}
+
+func TestMAC(t *testing.T) {
+	ks, err := dukpt.Derive(tdesBDK, mustHex("FFFF9876543210E00001"))
+	if err != nil {
+		t.Fatalf("invalid Derive: %s", err.Error())
+	}
+	compute := mac.WithKey(mac.ISO9797Alg3, mac.StaticKey(ks[dukpt.KeyMACRequest].Value))
+	a, err := compute([]byte("0200 test message"))
+	if err != nil {
+		t.Fatalf("invalid MAC: %s", err.Error())
+	}
+
+	other, _ := dukpt.Derive(tdesBDK, mustHex("FFFF9876543210E00002"))
+	b, _ := mac.ISO9797Alg3(other[dukpt.KeyMACRequest].Value, []byte("0200 test message"))
+	if mac.Equal(a, b) {
+		t.Fatalf("MAC with different KSN must be different")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	if _, err := dukpt.Derive(tdesBDK, mustHex("FFFF9876543210E0")); err != dukpt.ErrInvalidKSN {
+		t.Fatalf("invalid error for short KSN: %v", err)
+	}
+	if _, err := dukpt.Derive(tdesBDK, mustHex("FFFF9876543210E00000")); err != dukpt.ErrInvalidKSN {
+		t.Fatalf("invalid error for zero counter: %v", err)
+	}
+	if _, err := dukpt.Derive(tdesBDK, mustHex("FFFF9876543210E007FF")); err != dukpt.ErrInvalidKSN {
+		t.Fatalf("invalid error for counter with more than 10 bits: %v", err)
+	}
+	if _, err := dukpt.Derive(aesBDK, mustHex("FFFF9876543210E00001")); err != dukpt.ErrInvalidKSN {
+		t.Fatalf("invalid error for TDES KSN with AES BDK: %v", err)
+	}
+	if _, err := dukpt.Derive(keys.Key{Type: keys.TDES, Value: mustHex("0123456789ABCDEF")}, mustHex("FFFF9876543210E00001")); err != dukpt.ErrInvalidKey {
+		t.Fatalf("invalid error for single length BDK: %v", err)
+	}
+
+	if c, err := dukpt.Counter(mustHex("FFFF9876543210E00003")); err != nil || c != 3 {
+		t.Fatalf("invalid Counter: %d %v", c, err)
+	}
+	if c, err := dukpt.Counter(mustHex("123456789012345600010000")); err != nil || c != 0x10000 {
+		t.Fatalf("invalid Counter: %d %v", c, err)
+	}
+}
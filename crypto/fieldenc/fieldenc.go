@@ -0,0 +1,258 @@
+// Package fieldenc implement field level encryption of sensitive data elements, e.g. PAN (2), track 2 (35) and track 1 (45).
+package fieldenc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+// ErrInvalidCiphertext is the cause of *spec.ErrRejected returned by MsgDecode when encrypted field cannot be decrypted.
+var ErrInvalidCiphertext = fmt.Errorf("invalid encrypted field")
+
+var randReader io.Reader = rand.Reader
+
+// Encoding of the encrypted value inside the message field.
+type Encoding int
+
+// Encoding values
+const (
+	// EncodingRaw write the encrypted bytes as is, for binary field
+	EncodingRaw Encoding = iota
+
+	// EncodingHex write the encrypted bytes as uppercase hex digits
+	EncodingHex
+)
+
+// KeyFunc return the cipher and the name of the data key used for msg, the cipher can be hsm.HSM or keys.Static,
+// msg is the clear message when encrypting and the decoded message when decrypting.
+type KeyFunc func(msg spec.Msg) (keys.DataCipher, string, error)
+
+// StaticKey .
+func StaticKey(c keys.DataCipher, name string) KeyFunc {
+	return func(spec.Msg) (keys.DataCipher, string, error) {
+		return c, name, nil
+	}
+}
+
+// Config for Wrap.
+type Config struct {
+	// Key return the data key, see StaticKey
+	Key KeyFunc
+
+	// Fields to encrypt
+	Fields []int
+
+	// Encoding of the encrypted value in the field
+	Encoding Encoding
+
+	// IndicatorField is the private field that list the encrypted fields as 3 digits field numbers, e.g. "002035",
+	// it is set on outbound message, and on inbound message only the listed fields are decrypted.
+	// Zero means no indicator, every configured field is always encrypted.
+	IndicatorField int
+
+	// RandomIV prepend random IV to every encrypted value, so equal values produce different ciphertext,
+	// the host must expect the IV as the first block of the value
+	RandomIV bool
+}
+
+// Wrap return spec.Spec that encrypt cfg.Fields before MsgEncode and decrypt them after MsgDecode of s,
+// in CBC mode with ISO/IEC 9797-1 padding method 2, with zero IV unless cfg.RandomIV is set.
+// Inbound message that cannot be decrypted is rejected with ErrInvalidCiphertext, see spec.ErrRejected.
+// Validation must be the outer decorator, e.g. spec.Decorate(fieldenc.Wrap(s, cfg), rules.Hooks()).
+func Wrap(s spec.Spec, cfg Config) spec.Spec {
+	w := &wrapper{cfg: cfg}
+	return spec.Decorate(s, spec.Hooks{
+		BeforeEncode: w.beforeEncode,
+		AfterDecode:  w.afterDecode,
+	})
+}
+
+type wrapper struct {
+	cfg Config
+}
+
+func (w *wrapper) beforeEncode(msg spec.Msg) (spec.Msg, error) {
+	var present []int
+	for _, f := range w.cfg.Fields {
+		if _, ok := msg[f]; ok {
+			present = append(present, f)
+		}
+	}
+	if w.cfg.IndicatorField != 0 {
+		msg = msg.Clone()
+		delete(msg, w.cfg.IndicatorField)
+	}
+	if len(present) == 0 {
+		return msg, nil
+	}
+
+	c, name, err := w.cfg.Key(msg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get data key: %s", err.Error())
+	}
+
+	sort.Ints(present)
+	msg = msg.Clone()
+	for _, f := range present {
+		encrypted, err := encrypt(c, name, []byte(msg[f]), w.cfg.RandomIV)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encrypt field %d: %s", f, err.Error())
+		}
+		if w.cfg.Encoding == EncodingHex {
+			msg[f] = strings.ToUpper(hex.EncodeToString(encrypted))
+		} else {
+			msg[f] = string(encrypted)
+		}
+	}
+
+	if w.cfg.IndicatorField != 0 {
+		var indicator strings.Builder
+		for _, f := range present {
+			fmt.Fprintf(&indicator, "%03d", f)
+		}
+		msg[w.cfg.IndicatorField] = indicator.String()
+	}
+
+	return msg, nil
+}
+
+func (w *wrapper) afterDecode(msg spec.Msg, raw []byte) (spec.Msg, error) {
+	fields := w.cfg.Fields
+	if w.cfg.IndicatorField != 0 {
+		var err error
+		fields, err = parseIndicator(msg[w.cfg.IndicatorField])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var present []int
+	for _, f := range fields {
+		if _, ok := msg[f]; ok {
+			present = append(present, f)
+		}
+	}
+	if len(present) == 0 {
+		if w.cfg.IndicatorField != 0 {
+			delete(msg, w.cfg.IndicatorField)
+		}
+		return msg, nil
+	}
+
+	c, name, err := w.cfg.Key(msg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get data key: %s", err.Error())
+	}
+
+	msg = msg.Clone()
+	for _, f := range present {
+		encrypted := []byte(msg[f])
+		if w.cfg.Encoding == EncodingHex {
+			encrypted, err = hex.DecodeString(msg[f])
+			if err != nil {
+				return nil, fmt.Errorf("field %d: %w", f, ErrInvalidCiphertext)
+			}
+		}
+		clear, err := decrypt(c, name, encrypted, w.cfg.RandomIV)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", f, err)
+		}
+		msg[f] = string(clear)
+	}
+	if w.cfg.IndicatorField != 0 {
+		delete(msg, w.cfg.IndicatorField)
+	}
+
+	return msg, nil
+}
+
+func parseIndicator(value string) ([]int, error) {
+	if len(value)%3 != 0 {
+		return nil, fmt.Errorf("invalid encryption indicator: %q", value)
+	}
+	var ret []int
+	for i := 0; i < len(value); i += 3 {
+		f, err := strconv.Atoi(value[i : i+3])
+		if err != nil || f < 2 || f > 128 {
+			return nil, fmt.Errorf("invalid encryption indicator: %q", value)
+		}
+		ret = append(ret, f)
+	}
+	return ret, nil
+}
+
+func blockSize(c keys.DataCipher, name string) (int, error) {
+	t, err := c.KeyType(name)
+	if err != nil {
+		return 0, err
+	}
+	if t == keys.AES {
+		return 16, nil
+	}
+	return 8, nil
+}
+
+func encrypt(c keys.DataCipher, name string, data []byte, randomIV bool) ([]byte, error) {
+	bs, err := blockSize(c, name)
+	if err != nil {
+		return nil, err
+	}
+
+	padded := make([]byte, (len(data)/bs+1)*bs)
+	copy(padded, data)
+	padded[len(data)] = 0x80
+
+	if !randomIV {
+		return c.EncryptData(name, nil, padded)
+	}
+
+	iv := make([]byte, bs)
+	if _, err := io.ReadFull(randReader, iv); err != nil {
+		return nil, err
+	}
+	encrypted, err := c.EncryptData(name, iv, padded)
+	if err != nil {
+		return nil, err
+	}
+	return append(iv, encrypted...), nil
+}
+
+func decrypt(c keys.DataCipher, name string, data []byte, randomIV bool) ([]byte, error) {
+	bs, err := blockSize(c, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	if randomIV {
+		if len(data) < bs {
+			return nil, ErrInvalidCiphertext
+		}
+		iv, data = data[:bs], data[bs:]
+	}
+	if len(data) == 0 || len(data)%bs != 0 {
+		return nil, ErrInvalidCiphertext
+	}
+
+	ret, err := c.DecryptData(name, iv, data)
+	if err != nil {
+		return nil, err
+	}
+
+	end := len(ret) - 1
+	for end >= 0 && ret[end] == 0 {
+		end--
+	}
+	if end < 0 || ret[end] != 0x80 {
+		return nil, ErrInvalidCiphertext
+	}
+	return ret[:end], nil
+}
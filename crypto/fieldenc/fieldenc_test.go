@@ -0,0 +1,210 @@
+package fieldenc_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/dukpt"
+	"github.com/payfazz/iso8585-utility-lib/crypto/fieldenc"
+	"github.com/payfazz/iso8585-utility-lib/crypto/hsm"
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+var testKeys = keys.Static{
+	"tdes": {Type: keys.TDES, Value: mustHex("0123456789ABCDEFFEDCBA9876543210")},
+	"aes":  {Type: keys.AES, Value: mustHex("2B7E151628AED2A6ABF7158809CF4F3C")},
+}
+
+func TestWrapConformance(t *testing.T) {
+	for _, name := range []string{"tdes", "aes"} {
+		s := fieldenc.Wrap(&spectest.RefSpec{}, fieldenc.Config{
+			Key:            fieldenc.StaticKey(testKeys, name),
+			Fields:         []int{2, 35, 45},
+			Encoding:       fieldenc.EncodingHex,
+			IndicatorField: 62,
+		})
+		spectest.Run(t, s, spectest.Config{
+			Samples: []spec.Msg{
+				{0: "0200", 2: "4111111111111111", 3: "000000", 11: "000001", 35: "4111111111111111=25121010000000000000", 41: "TERM0001"},
+				{0: "0200", 3: "000000", 11: "000002", 41: "TERM0001"},
+				{0: "0200", 2: "41111111", 11: "000003", 45: "B4111111111111111^DOE/JOHN^2512101"},
+			},
+		})
+	}
+}
+
+func TestWrapEncrypted(t *testing.T) {
+	s := fieldenc.Wrap(&spectest.RefSpec{}, fieldenc.Config{
+		Key:            fieldenc.StaticKey(testKeys, "tdes"),
+		Fields:         []int{2, 35},
+		Encoding:       fieldenc.EncodingHex,
+		IndicatorField: 62,
+	})
+
+	msg := spec.Msg{0: "0200", 2: "4111111111111111", 11: "000001"}
+	encoded, err := s.MsgEncode(msg)
+	if err != nil {
+		t.Fatalf("invalid MsgEncode: %s", err.Error())
+	}
+	if bytes.Contains(encoded, []byte("4111111111111111")) {
+		t.Fatalf("PAN must not be sent in clear")
+	}
+	if len(msg) != 3 || msg[2] != "4111111111111111" {
+		t.Fatalf("MsgEncode must not modify the original message")
+	}
+
+	_, raw, _, err := (&spectest.RefSpec{}).MsgDecode(encoded)
+	if err != nil {
+		t.Fatalf("invalid MsgDecode: %s", err.Error())
+	}
+	if raw[62] != "002" {
+		t.Fatalf("invalid indicator: %q", raw[62])
+	}
+	if len(raw[2]) != 48 {
+		t.Fatalf("invalid encrypted PAN length: %d", len(raw[2]))
+	}
+
+	// inbound message without indicator is not decrypted
+	plain, _ := (&spectest.RefSpec{}).MsgEncode(spec.Msg{0: "0210", 2: "4111111111111111", 11: "000001"})
+	_, decoded, _, err := s.MsgDecode(plain)
+	if err != nil || decoded[2] != "4111111111111111" {
+		t.Fatalf("invalid MsgDecode without indicator: %v %v", decoded, err)
+	}
+
+	// tampered ciphertext
+	raw[2] = raw[2][:46] + "00"
+	tampered, _ := (&spectest.RefSpec{}).MsgEncode(raw)
+	if _, _, _, err := s.MsgDecode(tampered); !errors.Is(err, fieldenc.ErrInvalidCiphertext) {
+		t.Fatalf("invalid err: %v", err)
+	}
+}
+
+func TestWrapWithoutIndicator(t *testing.T) {
+	s := fieldenc.Wrap(&spectest.RefSpec{}, fieldenc.Config{
+		Key:      fieldenc.StaticKey(testKeys, "aes"),
+		Fields:   []int{2},
+		Encoding: fieldenc.EncodingHex,
+	})
+	encoded, err := s.MsgEncode(spec.Msg{0: "0200", 2: "4111111111111111", 11: "000001"})
+	if err != nil {
+		t.Fatalf("invalid MsgEncode: %s", err.Error())
+	}
+	_, raw, _, _ := (&spectest.RefSpec{}).MsgDecode(encoded)
+	if len(raw[2]) != 64 {
+		t.Fatalf("invalid encrypted PAN length: %d", len(raw[2]))
+	}
+	if _, ok := raw[62]; ok {
+		t.Fatalf("indicator must not be set")
+	}
+	_, decoded, _, err := s.MsgDecode(encoded)
+	if err != nil || decoded[2] != "4111111111111111" {
+		t.Fatalf("invalid MsgDecode: %v %v", decoded, err)
+	}
+}
+
+func TestWrapHSM(t *testing.T) {
+	s, err := hsm.NewSoftware("", mustHex("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F"))
+	if err != nil {
+		t.Fatalf("invalid NewSoftware: %s", err.Error())
+	}
+	s.SetKey("dek", testKeys["aes"])
+
+	var h hsm.HSM = s
+	withHSM := fieldenc.Wrap(&spectest.RefSpec{}, fieldenc.Config{Key: fieldenc.StaticKey(h, "dek"), Fields: []int{2}, Encoding: fieldenc.EncodingHex})
+	withStatic := fieldenc.Wrap(&spectest.RefSpec{}, fieldenc.Config{Key: fieldenc.StaticKey(testKeys, "aes"), Fields: []int{2}, Encoding: fieldenc.EncodingHex})
+
+	msg := spec.Msg{0: "0200", 2: "4111111111111111", 11: "000001"}
+	a, err := withHSM.MsgEncode(msg)
+	if err != nil {
+		t.Fatalf("invalid MsgEncode: %s", err.Error())
+	}
+	b, _ := withStatic.MsgEncode(msg)
+	if !bytes.Equal(a, b) {
+		t.Fatalf("invalid ciphertext: %q, expected %q", a, b)
+	}
+
+	_, decoded, _, err := withHSM.MsgDecode(a)
+	if err != nil || decoded[2] != "4111111111111111" {
+		t.Fatalf("invalid MsgDecode: %v %v", decoded, err)
+	}
+}
+
+func TestWrapDUKPT(t *testing.T) {
+	bdk := keys.Key{Type: keys.TDES, Value: mustHex("0123456789ABCDEFFEDCBA9876543210")}
+	key := func(msg spec.Msg) (keys.DataCipher, string, error) {
+		ksn, err := hex.DecodeString(msg[53])
+		if err != nil {
+			return nil, "", err
+		}
+		ks, err := dukpt.Derive(bdk, ksn)
+		if err != nil {
+			return nil, "", err
+		}
+		return ks, dukpt.KeyDataRequest, nil
+	}
+	s := fieldenc.Wrap(&spectest.RefSpec{}, fieldenc.Config{Key: key, Fields: []int{35}, Encoding: fieldenc.EncodingHex})
+
+	var encrypted []string
+	for _, ksn := range []string{"FFFF9876543210E00001", "FFFF9876543210E00002"} {
+		encoded, err := s.MsgEncode(spec.Msg{0: "0200", 11: "000001", 35: "4111111111111111=2512", 53: ksn})
+		if err != nil {
+			t.Fatalf("invalid MsgEncode: %s", err.Error())
+		}
+		_, raw, _, _ := (&spectest.RefSpec{}).MsgDecode(encoded)
+		encrypted = append(encrypted, raw[35])
+
+		_, decoded, _, err := s.MsgDecode(encoded)
+		if err != nil || decoded[35] != "4111111111111111=2512" {
+			t.Fatalf("invalid MsgDecode: %v %v", decoded, err)
+		}
+	}
+	if encrypted[0] == encrypted[1] {
+		t.Fatalf("different KSN must produce different ciphertext")
+	}
+}
+
+func TestWrapRandomIV(t *testing.T) {
+	for _, name := range []string{"tdes", "aes"} {
+		s := fieldenc.Wrap(&spectest.RefSpec{}, fieldenc.Config{
+			Key:      fieldenc.StaticKey(testKeys, name),
+			Fields:   []int{2},
+			Encoding: fieldenc.EncodingHex,
+			RandomIV: true,
+		})
+
+		var encrypted []string
+		for i := 0; i < 2; i++ {
+			encoded, err := s.MsgEncode(spec.Msg{0: "0200", 2: "4111111111111111", 11: "000001"})
+			if err != nil {
+				t.Fatalf("invalid MsgEncode: %s", err.Error())
+			}
+			_, raw, _, _ := (&spectest.RefSpec{}).MsgDecode(encoded)
+			encrypted = append(encrypted, raw[2])
+
+			_, decoded, _, err := s.MsgDecode(encoded)
+			if err != nil || decoded[2] != "4111111111111111" {
+				t.Fatalf("invalid MsgDecode: %v %v", decoded, err)
+			}
+		}
+		// 16 bytes PAN is padded to 24 (TDES) or 32 (AES) bytes, plus one block of IV
+		expectedLen := map[string]int{"tdes": 64, "aes": 96}[name]
+		if len(encrypted[0]) != expectedLen {
+			t.Fatalf("%s: invalid encrypted PAN length: %d", name, len(encrypted[0]))
+		}
+		if encrypted[0] == encrypted[1] {
+			t.Fatalf("%s: equal value must produce different ciphertext", name)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package hsm define the HSM interface used for PIN, MAC and key exchange operations,
+// and Software, a local stand-in backed by an encrypted keystore file.
+package hsm
+
+import (
+	"fmt"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+	"github.com/payfazz/iso8585-utility-lib/crypto/pinblock"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+// ErrInvalidKCV is returned by ImportKey when the key check value doesn't match.
+var ErrInvalidKCV = fmt.Errorf("invalid key check value")
+
+// MACAlgorithm .
+type MACAlgorithm int
+
+// MACAlgorithm values, see package mac
+const (
+	MACISO9797Alg1 MACAlgorithm = iota
+	MACISO9797Alg3
+	MACAESCMAC
+)
+
+// PINKey is the key and the format of PIN block.
+type PINKey struct {
+	Name   string
+	Format pinblock.Format
+}
+
+// WrappedKey is key encrypted under a key encryption key (KEK), e.g. working key sent by the host.
+type WrappedKey struct {
+	Type  keys.Type
+	Value []byte
+
+	// KCV is the key check value of the clear key, see keys.Key.CheckValue, it is not checked if empty
+	KCV []byte
+}
+
+// HSM hold keys and do cryptographic operations with them, clear keys never leave the HSM,
+// there is no generic block decryption, so neither a wrapped key nor a PIN can be recovered in clear.
+type HSM interface {
+	// KeyType return the type of named key
+	KeyType(name string) (keys.Type, error)
+
+	// TranslatePIN decrypt PIN block with in key and re-encrypt it with out key
+	TranslatePIN(block []byte, pan string, in, out PINKey) ([]byte, error)
+
+	// GenerateMAC compute MAC of data
+	GenerateMAC(key string, alg MACAlgorithm, data []byte) ([]byte, error)
+
+	// VerifyMAC return error if mac is not the MAC of data, mac can be truncated
+	VerifyMAC(key string, alg MACAlgorithm, data, mac []byte) error
+
+	// EncryptData encrypt data with data key in CBC mode, data length must be multiple of the block size,
+	// nil iv means zero IV, it is used for field level encryption, see keys.DataCipher and package fieldenc
+	EncryptData(key string, iv, data []byte) ([]byte, error)
+
+	// DecryptData decrypt data encrypted by EncryptData,
+	// the padding is not checked, so data encrypted with another key is not detected here
+	DecryptData(key string, iv, data []byte) ([]byte, error)
+
+	// ImportKey decrypt key with kek and store it as name, replacing existing key
+	ImportKey(name, kek string, key WrappedKey) error
+
+	// ExportKey return key name encrypted under kek
+	ExportKey(name, kek string) (WrappedKey, error)
+}
+
+var _ keys.DataCipher = HSM(nil)
+
+// MAC return function for mac.Config.Compute that compute the MAC in h with the key named by key.
+func MAC(h HSM, alg MACAlgorithm, key func() (string, error)) func(data []byte) ([]byte, error) {
+	return func(data []byte) ([]byte, error) {
+		name, err := key()
+		if err != nil {
+			return nil, err
+		}
+		return h.GenerateMAC(name, alg, data)
+	}
+}
+
+// StaticKeyName .
+func StaticKeyName(name string) func() (string, error) {
+	return func() (string, error) {
+		return name, nil
+	}
+}
+
+// WorkingMACKey return key function for MAC that use the MAC working key in holder,
+// the working keys must be key names in the HSM, see ImportWorkingKeys.
+func WorkingMACKey(holder *spec.KeyHolder) func() (string, error) {
+	return func() (string, error) {
+		keys := holder.WorkingKeys()
+		if keys == nil || len(keys.MAC) == 0 {
+			return "", fmt.Errorf("MAC working key not available")
+		}
+		return string(keys.MAC), nil
+	}
+}
+
+// ImportWorkingKeys import working keys received from the host into h,
+// it is intended to be called from upstream.KeyExchangeFunc.
+//
+// The keys are stored as prefix + "pin", prefix + "mac" and prefix + "data", key with empty Value is skipped.
+// The returned WorkingKeys contains the key names, not the clear keys.
+func ImportWorkingKeys(h HSM, kek, prefix string, pin, mac, data WrappedKey) (spec.WorkingKeys, error) {
+	var ret spec.WorkingKeys
+	for _, k := range []struct {
+		suffix string
+		key    WrappedKey
+		dst    *[]byte
+	}{
+		{"pin", pin, &ret.PIN},
+		{"mac", mac, &ret.MAC},
+		{"data", data, &ret.Data},
+	} {
+		if len(k.key.Value) == 0 {
+			continue
+		}
+		name := prefix + k.suffix
+		if err := h.ImportKey(name, kek, k.key); err != nil {
+			return spec.WorkingKeys{}, fmt.Errorf("cannot import %s key: %s", k.suffix, err.Error())
+		}
+		*k.dst = []byte(name)
+	}
+	return ret, nil
+}
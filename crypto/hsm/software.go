@@ -0,0 +1,310 @@
+package hsm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+	"github.com/payfazz/iso8585-utility-lib/crypto/mac"
+	"github.com/payfazz/iso8585-utility-lib/crypto/pinblock"
+	"github.com/payfazz/iso8585-utility-lib/internal/atomicfile"
+)
+
+// Software is HSM that keep clear keys in memory and persist them in a keystore file encrypted under a master key,
+// it also implements keys.Provider, so it is only for development and testing.
+type Software struct {
+	path      string
+	masterKey []byte
+
+	lock sync.RWMutex
+	keys keys.Static
+}
+
+var _ HSM = (*Software)(nil)
+
+var _ keys.Provider = (*Software)(nil)
+
+type keystoreFile struct {
+	Version int    `json:"version"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+const keystoreVersion = 1
+
+// NewSoftware open the keystore at path with masterKey (32 bytes),
+// the file is created on the first change if it doesn't exist.
+// Empty path means the keys are only kept in memory.
+func NewSoftware(path string, masterKey []byte) (*Software, error) {
+	if len(masterKey) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes")
+	}
+
+	s := &Software{
+		path:      path,
+		masterKey: append([]byte(nil), masterKey...),
+		keys:      make(keys.Static),
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	var file keystoreFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("invalid keystore: %s", err.Error())
+	}
+	if file.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version: %d", file.Version)
+	}
+
+	gcm, err := s.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid keystore: invalid nonce")
+	}
+	data, err := gcm.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt keystore, wrong master key or corrupted file")
+	}
+
+	if err := json.Unmarshal(data, &s.keys); err != nil {
+		return nil, fmt.Errorf("invalid keystore: %s", err.Error())
+	}
+
+	return s, nil
+}
+
+// SetKey store clear key as name, replacing existing key, e.g. to load the KEK from key ceremony.
+func (s *Software) SetKey(name string, key keys.Key) error {
+	if _, err := key.Block(); err != nil {
+		return err
+	}
+	return s.store(name, keys.Key{Type: key.Type, Value: append([]byte(nil), key.Value...)})
+}
+
+// GenerateKey generate random key with size bytes and store it as name, replacing existing key.
+func (s *Software) GenerateKey(name string, t keys.Type, size int) error {
+	key := keys.Key{Type: t, Value: make([]byte, size)}
+	if _, err := key.Block(); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(rand.Reader, key.Value); err != nil {
+		return err
+	}
+	return s.store(name, key)
+}
+
+// DeleteKey .
+func (s *Software) DeleteKey(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old, ok := s.keys[name]
+	if !ok {
+		return keys.ErrKeyNotFound
+	}
+	delete(s.keys, name)
+
+	if err := s.flush(); err != nil {
+		s.keys[name] = old
+		return err
+	}
+	return nil
+}
+
+// KeyType .
+func (s *Software) KeyType(name string) (keys.Type, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.keys.KeyType(name)
+}
+
+// EncryptBlock .
+func (s *Software) EncryptBlock(name string, data []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.keys.EncryptBlock(name, data)
+}
+
+// DecryptBlock .
+func (s *Software) DecryptBlock(name string, data []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.keys.DecryptBlock(name, data)
+}
+
+// TranslatePIN .
+func (s *Software) TranslatePIN(block []byte, pan string, in, out PINKey) ([]byte, error) {
+	return pinblock.Translate(s, block, pan, in.Name, in.Format, out.Name, out.Format)
+}
+
+// GenerateMAC .
+func (s *Software) GenerateMAC(key string, alg MACAlgorithm, data []byte) ([]byte, error) {
+	var fn mac.Algorithm
+	switch alg {
+	case MACISO9797Alg1:
+		fn = mac.ISO9797Alg1
+	case MACISO9797Alg3:
+		fn = mac.ISO9797Alg3
+	case MACAESCMAC:
+		fn = mac.AESCMAC
+	default:
+		return nil, fmt.Errorf("unsupported MAC algorithm: %d", alg)
+	}
+
+	k, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return fn(k.Value, data)
+}
+
+// VerifyMAC .
+func (s *Software) VerifyMAC(key string, alg MACAlgorithm, data, expected []byte) error {
+	computed, err := s.GenerateMAC(key, alg, data)
+	if err != nil {
+		return err
+	}
+	if len(expected) == 0 || len(expected) > len(computed) || !mac.Equal(computed[:len(expected)], expected) {
+		return mac.ErrInvalidMAC
+	}
+	return nil
+}
+
+// EncryptData encrypt data with data key in CBC mode, see HSM.
+func (s *Software) EncryptData(key string, iv, data []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.keys.EncryptData(key, iv, data)
+}
+
+// DecryptData decrypt data encrypted by EncryptData, see HSM.
+func (s *Software) DecryptData(key string, iv, data []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.keys.DecryptData(key, iv, data)
+}
+
+// ImportKey .
+func (s *Software) ImportKey(name, kek string, key WrappedKey) error {
+	value, err := s.DecryptBlock(kek, key.Value)
+	if err != nil {
+		return err
+	}
+
+	clear := keys.Key{Type: key.Type, Value: value}
+	kcv, err := clear.CheckValue()
+	if err != nil {
+		return err
+	}
+	if len(key.KCV) > 0 && (len(key.KCV) > len(kcv) || subtle.ConstantTimeCompare(kcv[:len(key.KCV)], key.KCV) != 1) {
+		return ErrInvalidKCV
+	}
+
+	return s.store(name, clear)
+}
+
+// ExportKey .
+func (s *Software) ExportKey(name, kek string) (WrappedKey, error) {
+	k, err := s.get(name)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	kcv, err := k.CheckValue()
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	wrapped, err := s.EncryptBlock(kek, k.Value)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	return WrappedKey{Type: k.Type, Value: wrapped, KCV: kcv}, nil
+}
+
+func (s *Software) get(name string) (keys.Key, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	k, ok := s.keys[name]
+	if !ok {
+		return keys.Key{}, keys.ErrKeyNotFound
+	}
+	return k, nil
+}
+
+func (s *Software) store(name string, key keys.Key) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old, hadOld := s.keys[name]
+	s.keys[name] = key
+
+	if err := s.flush(); err != nil {
+		if hadOld {
+			s.keys[name] = old
+		} else {
+			delete(s.keys, name)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Software) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *Software) flush() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.keys)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := s.gcm()
+	if err != nil {
+		return err
+	}
+	file := keystoreFile{Version: keystoreVersion, Nonce: make([]byte, gcm.NonceSize())}
+	if _, err := io.ReadFull(rand.Reader, file.Nonce); err != nil {
+		return err
+	}
+	file.Data = gcm.Seal(nil, file.Nonce, data, nil)
+
+	raw, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.Write(s.path, raw)
+}
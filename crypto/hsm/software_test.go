@@ -0,0 +1,251 @@
+package hsm_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/hsm"
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+	"github.com/payfazz/iso8585-utility-lib/crypto/mac"
+	"github.com/payfazz/iso8585-utility-lib/crypto/pinblock"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+var masterKey = bytes.Repeat([]byte{0x42}, 32)
+
+func TestKeystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+
+	s, err := hsm.NewSoftware(path, masterKey)
+	if err != nil {
+		t.Fatalf("invalid NewSoftware: %s", err.Error())
+	}
+	if err := s.SetKey("zmk", keys.Key{Type: keys.TDES, Value: mustHex("0123456789ABCDEFFEDCBA9876543210")}); err != nil {
+		t.Fatalf("invalid SetKey: %s", err.Error())
+	}
+	if err := s.GenerateKey("zak", keys.AES, 16); err != nil {
+		t.Fatalf("invalid GenerateKey: %s", err.Error())
+	}
+	if err := s.SetKey("bad", keys.Key{Type: keys.AES, Value: []byte{1, 2, 3}}); err == nil {
+		t.Fatalf("SetKey with invalid key must fail")
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("keystore file is not written: %s", err.Error())
+	}
+	if bytes.Contains(raw, []byte("0123456789")) || bytes.Contains(raw, []byte("ASNFZ4mrze/+3LqYdlQyEA==")) {
+		t.Fatalf("keystore file must not contain clear key")
+	}
+
+	reopened, err := hsm.NewSoftware(path, masterKey)
+	if err != nil {
+		t.Fatalf("invalid reopen: %s", err.Error())
+	}
+	a, _ := s.EncryptBlock("zak", make([]byte, 16))
+	b, err := reopened.EncryptBlock("zak", make([]byte, 16))
+	if err != nil || !bytes.Equal(a, b) {
+		t.Fatalf("reopened keystore must have the same keys")
+	}
+	if typ, err := reopened.KeyType("zmk"); err != nil || typ != keys.TDES {
+		t.Fatalf("invalid KeyType: %v %v", typ, err)
+	}
+
+	if _, err := hsm.NewSoftware(path, bytes.Repeat([]byte{0x43}, 32)); err == nil {
+		t.Fatalf("NewSoftware with wrong master key must fail")
+	}
+
+	if err := reopened.DeleteKey("zak"); err != nil {
+		t.Fatalf("invalid DeleteKey: %s", err.Error())
+	}
+	again, _ := hsm.NewSoftware(path, masterKey)
+	if _, err := again.KeyType("zak"); err != keys.ErrKeyNotFound {
+		t.Fatalf("deleted key must not be persisted: %v", err)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	host, _ := hsm.NewSoftware("", masterKey)
+	local, _ := hsm.NewSoftware("", masterKey)
+
+	zmk := keys.Key{Type: keys.TDES, Value: mustHex("0123456789ABCDEFFEDCBA9876543210")}
+	host.SetKey("zmk", zmk)
+	local.SetKey("zmk", zmk)
+
+	if err := host.GenerateKey("zpk", keys.TDES, 16); err != nil {
+		t.Fatalf("invalid GenerateKey: %s", err.Error())
+	}
+	wrapped, err := host.ExportKey("zpk", "zmk")
+	if err != nil {
+		t.Fatalf("invalid ExportKey: %s", err.Error())
+	}
+	if len(wrapped.KCV) != 3 {
+		t.Fatalf("invalid KCV length: %d", len(wrapped.KCV))
+	}
+
+	if err := local.ImportKey("zpk", "zmk", wrapped); err != nil {
+		t.Fatalf("invalid ImportKey: %s", err.Error())
+	}
+	a, _ := host.EncryptBlock("zpk", make([]byte, 8))
+	b, _ := local.EncryptBlock("zpk", make([]byte, 8))
+	if !bytes.Equal(a, b) {
+		t.Fatalf("imported key is different")
+	}
+
+	wrapped.KCV = []byte{0, 0, 0}
+	if err := local.ImportKey("other", "zmk", wrapped); err != hsm.ErrInvalidKCV {
+		t.Fatalf("invalid error for wrong KCV: %v", err)
+	}
+	if _, err := local.KeyType("other"); err != keys.ErrKeyNotFound {
+		t.Fatalf("key with wrong KCV must not be stored")
+	}
+}
+
+func TestImportExportAES(t *testing.T) {
+	host, _ := hsm.NewSoftware("", masterKey)
+	local, _ := hsm.NewSoftware("", masterKey)
+
+	kek := keys.Key{Type: keys.AES, Value: mustHex("2B7E151628AED2A6ABF7158809CF4F3C")}
+	host.SetKey("kek", kek)
+	local.SetKey("kek", kek)
+	host.SetKey("dek", keys.Key{Type: keys.AES, Value: mustHex("000102030405060708090A0B0C0D0E0F")})
+
+	wrapped, err := host.ExportKey("dek", "kek")
+	if err != nil {
+		t.Fatalf("invalid ExportKey: %s", err.Error())
+	}
+
+	// AES KCV is the CMAC of a zero block
+	block, _ := aes.NewCipher(mustHex("000102030405060708090A0B0C0D0E0F"))
+	if !bytes.Equal(wrapped.KCV, mac.CMAC(block, make([]byte, 16))[:5]) {
+		t.Fatalf("invalid KCV: %X", wrapped.KCV)
+	}
+
+	// KCV sent by the host can be shorter
+	wrapped.KCV = wrapped.KCV[:3]
+	if err := local.ImportKey("dek", "kek", wrapped); err != nil {
+		t.Fatalf("invalid ImportKey: %s", err.Error())
+	}
+}
+
+func TestMAC(t *testing.T) {
+	s, _ := hsm.NewSoftware("", masterKey)
+	s.SetKey("mak", keys.Key{Type: keys.TDES, Value: mustHex("0123456789ABCDEFFEDCBA9876543210")})
+
+	data := []byte("Now is the time for all ")
+	got, err := s.GenerateMAC("mak", hsm.MACISO9797Alg3, data)
+	if err != nil {
+		t.Fatalf("invalid GenerateMAC: %s", err.Error())
+	}
+	if strings.ToUpper(hex.EncodeToString(got)) != "A1C72E74EA3FA9B6" {
+		t.Fatalf("invalid MAC: %X", got)
+	}
+
+	if err := s.VerifyMAC("mak", hsm.MACISO9797Alg3, data, got[:4]); err != nil {
+		t.Fatalf("invalid VerifyMAC with truncated MAC: %s", err.Error())
+	}
+	if err := s.VerifyMAC("mak", hsm.MACISO9797Alg3, []byte("Now is the time for all!"), got); err != mac.ErrInvalidMAC {
+		t.Fatalf("invalid error for wrong MAC: %v", err)
+	}
+	if _, err := s.GenerateMAC("unknown", hsm.MACISO9797Alg3, data); err != keys.ErrKeyNotFound {
+		t.Fatalf("invalid error for unknown key: %v", err)
+	}
+}
+
+func TestTranslatePIN(t *testing.T) {
+	s, _ := hsm.NewSoftware("", masterKey)
+	s.GenerateKey("tpk", keys.TDES, 16)
+	s.GenerateKey("zpk", keys.AES, 16)
+
+	pan := "4111111111111111"
+	block, err := pinblock.Encrypt(s, "tpk", pinblock.Format0, "1234", pan)
+	if err != nil {
+		t.Fatalf("invalid Encrypt: %s", err.Error())
+	}
+	out, err := s.TranslatePIN(block, pan, hsm.PINKey{Name: "tpk", Format: pinblock.Format0}, hsm.PINKey{Name: "zpk", Format: pinblock.Format4})
+	if err != nil {
+		t.Fatalf("invalid TranslatePIN: %s", err.Error())
+	}
+	if pin, err := pinblock.Decrypt(s, "zpk", pinblock.Format4, out, pan); err != nil || pin != "1234" {
+		t.Fatalf("invalid translated PIN block: %q %v", pin, err)
+	}
+}
+
+func TestImportWorkingKeys(t *testing.T) {
+	host, _ := hsm.NewSoftware("", masterKey)
+	local, _ := hsm.NewSoftware("", masterKey)
+	zmk := keys.Key{Type: keys.TDES, Value: mustHex("0123456789ABCDEFFEDCBA9876543210")}
+	host.SetKey("zmk", zmk)
+	local.SetKey("zmk", zmk)
+
+	host.GenerateKey("mak", keys.TDES, 16)
+	wrappedMAC, _ := host.ExportKey("mak", "zmk")
+
+	wk, err := hsm.ImportWorkingKeys(local, "zmk", "conn1-", hsm.WrappedKey{}, wrappedMAC, hsm.WrappedKey{})
+	if err != nil {
+		t.Fatalf("invalid ImportWorkingKeys: %s", err.Error())
+	}
+	if string(wk.MAC) != "conn1-mac" || wk.PIN != nil || wk.Data != nil {
+		t.Fatalf("invalid working keys: %+v", wk)
+	}
+
+	var holder spec.KeyHolder
+	compute := hsm.MAC(local, hsm.MACISO9797Alg3, hsm.WorkingMACKey(&holder))
+	if _, err := compute([]byte("data")); err == nil {
+		t.Fatalf("MAC without working keys must fail")
+	}
+
+	holder.SetWorkingKeys(&wk)
+	got, err := compute([]byte("data"))
+	if err != nil {
+		t.Fatalf("invalid MAC: %s", err.Error())
+	}
+	if err := host.VerifyMAC("mak", hsm.MACISO9797Alg3, []byte("data"), got); err != nil {
+		t.Fatalf("MAC with imported working key must be verified by the host: %s", err.Error())
+	}
+}
+
+func TestEncryptData(t *testing.T) {
+	s, _ := hsm.NewSoftware("", masterKey)
+	s.SetKey("dek", keys.Key{Type: keys.AES, Value: mustHex("2B7E151628AED2A6ABF7158809CF4F3C")})
+
+	// NIST SP 800-38A F.2.1 CBC-AES128.Encrypt, first block
+	iv := mustHex("000102030405060708090A0B0C0D0E0F")
+	data := mustHex("6BC1BEE22E409F96E93D7E117393172A")
+	got, err := s.EncryptData("dek", iv, data)
+	if err != nil {
+		t.Fatalf("invalid EncryptData: %s", err.Error())
+	}
+	if strings.ToUpper(hex.EncodeToString(got)) != "7649ABAC8119B246CEE98E9B12E9197D" {
+		t.Fatalf("invalid ciphertext: %X", got)
+	}
+	if clear, err := s.DecryptData("dek", iv, got); err != nil || !bytes.Equal(clear, data) {
+		t.Fatalf("invalid DecryptData: %X %v", clear, err)
+	}
+
+	zero, _ := s.EncryptData("dek", nil, data)
+	ecb, _ := s.EncryptBlock("dek", data)
+	if !bytes.Equal(zero, ecb) {
+		t.Fatalf("nil IV must be zero IV")
+	}
+
+	if _, err := s.EncryptData("dek", nil, data[:15]); err != keys.ErrInvalidDataLength {
+		t.Fatalf("invalid error for invalid data length: %v", err)
+	}
+	if _, err := s.EncryptData("dek", iv[:8], data); err == nil {
+		t.Fatalf("EncryptData with invalid IV must fail")
+	}
+}
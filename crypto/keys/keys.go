@@ -0,0 +1,219 @@
+// Package keys provide symmetric key types and the Provider interface used by PIN, MAC and data encryption.
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"fmt"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/mac"
+)
+
+// Type of key.
+type Type int
+
+// Type values
+const (
+	TDES Type = iota
+	AES
+)
+
+func (t Type) String() string {
+	switch t {
+	case TDES:
+		return "TDES"
+	case AES:
+		return "AES"
+	default:
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+}
+
+// ErrKeyNotFound .
+var ErrKeyNotFound = fmt.Errorf("key not found")
+
+// ErrInvalidDataLength .
+var ErrInvalidDataLength = fmt.Errorf("data length is not multiple of block size")
+
+// Key is a clear symmetric key.
+type Key struct {
+	Type  Type
+	Value []byte
+}
+
+// Block return the block cipher of k.
+// TDES key can be single (8 bytes), double (16 bytes) or triple (24 bytes) length.
+func (k Key) Block() (cipher.Block, error) {
+	switch k.Type {
+	case TDES:
+		switch len(k.Value) {
+		case 8:
+			return des.NewCipher(k.Value)
+		case 16:
+			v := make([]byte, 24)
+			copy(v, k.Value)
+			copy(v[16:], k.Value[:8])
+			return des.NewTripleDESCipher(v)
+		case 24:
+			return des.NewTripleDESCipher(k.Value)
+		}
+	case AES:
+		switch len(k.Value) {
+		case 16, 24, 32:
+			return aes.NewCipher(k.Value)
+		}
+	}
+	return nil, fmt.Errorf("invalid %s key length: %d", k.Type, len(k.Value))
+}
+
+// EncryptECB encrypt data with k in ECB mode, data length must be multiple of the block size.
+func (k Key) EncryptECB(data []byte) ([]byte, error) {
+	block, err := k.Block()
+	if err != nil {
+		return nil, err
+	}
+	return ecb(block, data, block.Encrypt)
+}
+
+// DecryptECB decrypt data with k in ECB mode, data length must be multiple of the block size.
+func (k Key) DecryptECB(data []byte) ([]byte, error) {
+	block, err := k.Block()
+	if err != nil {
+		return nil, err
+	}
+	return ecb(block, data, block.Decrypt)
+}
+
+// CheckValue return the key check value of k.
+// For TDES key, it is the first 3 bytes of a zero block encrypted with k,
+// for AES key, it is the first 5 bytes of the CMAC of a zero block (ANSI X9.24-1:2017).
+func (k Key) CheckValue() ([]byte, error) {
+	block, err := k.Block()
+	if err != nil {
+		return nil, err
+	}
+	zero := make([]byte, block.BlockSize())
+	if k.Type == AES {
+		return mac.CMAC(block, zero)[:5], nil
+	}
+	block.Encrypt(zero, zero)
+	return zero[:3], nil
+}
+
+// EncryptCBC encrypt data with k in CBC mode, data length must be multiple of the block size, nil iv means zero IV.
+func (k Key) EncryptCBC(iv, data []byte) ([]byte, error) {
+	return k.cbc(iv, data, cipher.NewCBCEncrypter)
+}
+
+// DecryptCBC decrypt data with k in CBC mode, data length must be multiple of the block size, nil iv means zero IV.
+func (k Key) DecryptCBC(iv, data []byte) ([]byte, error) {
+	return k.cbc(iv, data, cipher.NewCBCDecrypter)
+}
+
+func (k Key) cbc(iv, data []byte, mode func(cipher.Block, []byte) cipher.BlockMode) ([]byte, error) {
+	block, err := k.Block()
+	if err != nil {
+		return nil, err
+	}
+	bs := block.BlockSize()
+	if iv == nil {
+		iv = make([]byte, bs)
+	}
+	if len(iv) != bs {
+		return nil, fmt.Errorf("invalid IV length: %d", len(iv))
+	}
+	if len(data) == 0 || len(data)%bs != 0 {
+		return nil, ErrInvalidDataLength
+	}
+	ret := make([]byte, len(data))
+	mode(block, iv).CryptBlocks(ret, data)
+	return ret, nil
+}
+
+func ecb(block cipher.Block, data []byte, fn func(dst, src []byte)) ([]byte, error) {
+	bs := block.BlockSize()
+	if len(data) == 0 || len(data)%bs != 0 {
+		return nil, ErrInvalidDataLength
+	}
+	ret := make([]byte, len(data))
+	for i := 0; i < len(data); i += bs {
+		fn(ret[i:i+bs], data[i:i+bs])
+	}
+	return ret, nil
+}
+
+// Provider encrypt and decrypt blocks with named keys, without exposing the keys.
+// Real HSM can implement this interface.
+type Provider interface {
+	// KeyType return the type of named key
+	KeyType(name string) (Type, error)
+
+	// EncryptBlock encrypt data in ECB mode with named key
+	EncryptBlock(name string, data []byte) ([]byte, error)
+
+	// DecryptBlock decrypt data in ECB mode with named key
+	DecryptBlock(name string, data []byte) ([]byte, error)
+}
+
+// DataCipher encrypt and decrypt data with named data keys, e.g. for field level encryption.
+// Both Static and hsm.HSM implement this interface.
+type DataCipher interface {
+	// KeyType return the type of named key
+	KeyType(name string) (Type, error)
+
+	// EncryptData encrypt data in CBC mode with named key, data length must be multiple of the block size,
+	// nil iv means zero IV
+	EncryptData(name string, iv, data []byte) ([]byte, error)
+
+	// DecryptData decrypt data encrypted by EncryptData
+	DecryptData(name string, iv, data []byte) ([]byte, error)
+}
+
+// Static is in-memory Provider, it should only be used for development and testing.
+type Static map[string]Key
+
+// KeyType .
+func (s Static) KeyType(name string) (Type, error) {
+	k, ok := s[name]
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	return k.Type, nil
+}
+
+// EncryptBlock .
+func (s Static) EncryptBlock(name string, data []byte) ([]byte, error) {
+	k, ok := s[name]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return k.EncryptECB(data)
+}
+
+// DecryptBlock .
+func (s Static) DecryptBlock(name string, data []byte) ([]byte, error) {
+	k, ok := s[name]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return k.DecryptECB(data)
+}
+
+// EncryptData encrypt data with named key in CBC mode, see Key.EncryptCBC.
+func (s Static) EncryptData(name string, iv, data []byte) ([]byte, error) {
+	k, ok := s[name]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return k.EncryptCBC(iv, data)
+}
+
+// DecryptData decrypt data with named key in CBC mode, see Key.DecryptCBC.
+func (s Static) DecryptData(name string, iv, data []byte) ([]byte, error) {
+	k, ok := s[name]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return k.DecryptCBC(iv, data)
+}
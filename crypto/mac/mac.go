@@ -0,0 +1,159 @@
+// Package mac implement message authentication code used in ISO 8583 (field 64 and 128).
+package mac
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/subtle"
+	"fmt"
+)
+
+// Algorithm compute MAC of data using key.
+type Algorithm func(key, data []byte) ([]byte, error)
+
+// ErrInvalidKeyLength .
+var ErrInvalidKeyLength = fmt.Errorf("invalid key length")
+
+// ISO9797Alg1 compute ISO/IEC 9797-1 MAC algorithm 1 (CBC-MAC) with padding method 1 (zero padding).
+// key is single (8 bytes), double (16 bytes) or triple (24 bytes) length DES key.
+// With single length key, this is also ANSI X9.9 MAC.
+func ISO9797Alg1(key, data []byte) ([]byte, error) {
+	block, err := desCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cbcMAC(block, padZero(data, block.BlockSize())), nil
+}
+
+// ISO9797Alg3 compute ISO/IEC 9797-1 MAC algorithm 3 (ANSI X9.19 retail MAC) with padding method 1 (zero padding).
+// key is double (16 bytes) or triple (24 bytes) length DES key,
+// the CBC-MAC is computed with the first key, and the last block is decrypted with the second key and encrypted with the third (or the first) key.
+func ISO9797Alg3(key, data []byte) ([]byte, error) {
+	if len(key) != 16 && len(key) != 24 {
+		return nil, ErrInvalidKeyLength
+	}
+
+	k1, _ := des.NewCipher(key[0:8])
+	k2, _ := des.NewCipher(key[8:16])
+	k3 := k1
+	if len(key) == 24 {
+		k3, _ = des.NewCipher(key[16:24])
+	}
+
+	ret := cbcMAC(k1, padZero(data, des.BlockSize))
+	k2.Decrypt(ret, ret)
+	k3.Encrypt(ret, ret)
+	return ret, nil
+}
+
+// AESCMAC compute CMAC (NIST SP 800-38B, RFC 4493) with AES key (16, 24 or 32 bytes).
+func AESCMAC(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrInvalidKeyLength
+	}
+	return CMAC(block, data), nil
+}
+
+// CMAC compute CMAC (NIST SP 800-38B) with arbitrary block cipher with 8 or 16 bytes block size.
+func CMAC(block cipher.Block, data []byte) []byte {
+	bs := block.BlockSize()
+
+	var rb byte = 0x87
+	if bs == 8 {
+		rb = 0x1B
+	}
+
+	l := make([]byte, bs)
+	block.Encrypt(l, l)
+	k1 := shiftLeft(l, rb)
+	k2 := shiftLeft(k1, rb)
+
+	n := (len(data) + bs - 1) / bs
+	complete := n > 0 && len(data)%bs == 0
+	if n == 0 {
+		n = 1
+	}
+
+	last := make([]byte, bs)
+	if complete {
+		copy(last, data[(n-1)*bs:])
+		xorBytes(last, last, k1)
+	} else {
+		rest := data[(n-1)*bs:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		xorBytes(last, last, k2)
+	}
+
+	x := make([]byte, bs)
+	for i := 0; i < n-1; i++ {
+		xorBytes(x, x, data[i*bs:(i+1)*bs])
+		block.Encrypt(x, x)
+	}
+	xorBytes(x, x, last)
+	block.Encrypt(x, x)
+
+	return x
+}
+
+// Equal compare MAC in constant time.
+func Equal(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+func desCipher(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 8:
+		return des.NewCipher(key)
+	case 16:
+		k := make([]byte, 24)
+		copy(k, key)
+		copy(k[16:], key[:8])
+		return des.NewTripleDESCipher(k)
+	case 24:
+		return des.NewTripleDESCipher(key)
+	default:
+		return nil, ErrInvalidKeyLength
+	}
+}
+
+func cbcMAC(block cipher.Block, data []byte) []byte {
+	bs := block.BlockSize()
+	x := make([]byte, bs)
+	for i := 0; i < len(data); i += bs {
+		xorBytes(x, x, data[i:i+bs])
+		block.Encrypt(x, x)
+	}
+	return x
+}
+
+func padZero(data []byte, bs int) []byte {
+	n := len(data)
+	if n == 0 || n%bs != 0 {
+		n += bs - n%bs
+	}
+	ret := make([]byte, n)
+	copy(ret, data)
+	return ret
+}
+
+func shiftLeft(in []byte, rb byte) []byte {
+	out := make([]byte, len(in))
+	var carry byte
+	for i := len(in) - 1; i >= 0; i-- {
+		out[i] = in[i]<<1 | carry
+		carry = in[i] >> 7
+	}
+	if carry != 0 {
+		out[len(out)-1] ^= rb
+	}
+	return out
+}
+
+func xorBytes(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
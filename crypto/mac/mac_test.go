@@ -0,0 +1,81 @@
+package mac_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/mac"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// ANSI X9.9 / X9.19 classic test message: "Now is the time for all "
+var x9Data = []byte("Now is the time for all ")
+
+func TestISO9797Alg1(t *testing.T) {
+	// ANSI X9.9
+	output, err := mac.ISO9797Alg1(unhex("0123456789ABCDEF"), x9Data)
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if !bytes.Equal(output, unhex("70A30640CC76DD8B")) {
+		t.Fatalf("invalid mac: %X", output)
+	}
+}
+
+func TestISO9797Alg3(t *testing.T) {
+	// ANSI X9.19
+	output, err := mac.ISO9797Alg3(unhex("0123456789ABCDEF FEDCBA9876543210"), x9Data)
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if !bytes.Equal(output, unhex("A1C72E74EA3FA9B6")) {
+		t.Fatalf("invalid mac: %X", output)
+	}
+
+	// algorithm 3 with K1 == K2 is algorithm 1 with single DES
+	output, _ = mac.ISO9797Alg3(unhex("0123456789ABCDEF 0123456789ABCDEF"), x9Data)
+	if !bytes.Equal(output, unhex("70A30640CC76DD8B")) {
+		t.Fatalf("invalid mac: %X", output)
+	}
+
+	if _, err := mac.ISO9797Alg3(unhex("0123456789ABCDEF"), x9Data); err != mac.ErrInvalidKeyLength {
+		t.Fatalf("invalid err")
+	}
+}
+
+func TestAESCMAC(t *testing.T) {
+	// RFC 4493 section 4
+	key := unhex("2b7e1516 28aed2a6 abf71588 09cf4f3c")
+	message := unhex("" +
+		"6bc1bee2 2e409f96 e93d7e11 7393172a" +
+		"ae2d8a57 1e03ac9c 9eb76fac 45af8e51" +
+		"30c81c46 a35ce411 e5fbc119 1a0a52ef" +
+		"f69f2445 df4f9b17 ad2b417b e66c3710")
+	vectors := []struct {
+		len int
+		mac string
+	}{
+		{0, "bb1d6929 e9593728 7fa37d12 9b756746"},
+		{16, "070a16b4 6b4d4144 f79bdd9d d04a287c"},
+		{40, "dfa66747 de9ae630 30ca3261 1497c827"},
+		{64, "51f0bebf 7e3b9d92 fc497417 79363cfe"},
+	}
+	for _, v := range vectors {
+		output, err := mac.AESCMAC(key, message[:v.len])
+		if err != nil {
+			t.Fatalf("invalid err: %s", err.Error())
+		}
+		if !bytes.Equal(output, unhex(v.mac)) {
+			t.Fatalf("len %d: invalid mac: %x", v.len, output)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package mac
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+// ErrMissingMAC is the cause of *spec.ErrRejected returned by MsgDecode when inbound message doesn't have MAC.
+var ErrMissingMAC = fmt.Errorf("missing MAC")
+
+// ErrInvalidMAC is the cause of *spec.ErrRejected returned by MsgDecode when MAC of inbound message doesn't match.
+var ErrInvalidMAC = fmt.Errorf("invalid MAC")
+
+// Encoding of MAC inside the message field.
+type Encoding int
+
+// Encoding values
+const (
+	// EncodingRaw write the MAC bytes as is, for binary field
+	EncodingRaw Encoding = iota
+
+	// EncodingHex write the MAC as uppercase hex digits
+	EncodingHex
+)
+
+// Config for Wrap.
+type Config struct {
+	// Compute compute the MAC of data, see WithKey
+	Compute func(data []byte) ([]byte, error)
+
+	// Field is the MAC field, 0 means 64 if the message only have fields in primary bitmap, and 128 otherwise
+	Field int
+
+	// Size is the MAC size in bytes, the computed MAC is truncated to it, default to 8
+	Size int
+
+	// Encoding of the MAC in the field
+	Encoding Encoding
+
+	// Skip is the number of bytes at the beginning of the encoded message that is not covered by the MAC,
+	// e.g. the length header
+	Skip int
+
+	// SkipVerify disable verification of inbound message
+	SkipVerify bool
+}
+
+// WithKey return Config.Compute that use alg with the key returned by key,
+// key is called on every computation, so it can return the current working key.
+func WithKey(alg Algorithm, key func() ([]byte, error)) func(data []byte) ([]byte, error) {
+	return func(data []byte) ([]byte, error) {
+		k, err := key()
+		if err != nil {
+			return nil, err
+		}
+		return alg(k, data)
+	}
+}
+
+// StaticKey .
+func StaticKey(key []byte) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return key, nil
+	}
+}
+
+// Wrap return spec.Spec that add MAC to every outbound message and verify MAC of every inbound message.
+// The MAC is computed over MsgEncode of s (except the first cfg.Skip bytes) with zeros in the MAC field,
+// so s must encode the MAC field last and verbatim, which is the case for field 64 and 128 in most specs.
+// Inbound message with missing or invalid MAC is rejected, see spec.ErrRejected.
+func Wrap(s spec.Spec, cfg Config) spec.Spec {
+	if cfg.Size <= 0 {
+		cfg.Size = 8
+	}
+	w := &wrapper{cfg: cfg}
+	return spec.Decorate(s, spec.Hooks{
+		BeforeEncode: w.beforeEncode,
+		AfterEncode:  w.afterEncode,
+		AfterDecode:  w.afterDecode,
+	})
+}
+
+type wrapper struct {
+	cfg Config
+}
+
+func (w *wrapper) field(msg spec.Msg) int {
+	if w.cfg.Field != 0 {
+		return w.cfg.Field
+	}
+	for f := range msg {
+		if 64 < f && f != 128 {
+			return 128
+		}
+	}
+	return 64
+}
+
+func (w *wrapper) encodeValue(mac []byte) string {
+	if w.cfg.Encoding == EncodingHex {
+		return strings.ToUpper(hex.EncodeToString(mac))
+	}
+	return string(mac)
+}
+
+func (w *wrapper) placeholder() string {
+	return w.encodeValue(make([]byte, w.cfg.Size))
+}
+
+func (w *wrapper) beforeEncode(msg spec.Msg) (spec.Msg, error) {
+	msg = msg.Clone()
+	delete(msg, 64)
+	delete(msg, 128)
+	msg[w.field(msg)] = w.placeholder()
+	return msg, nil
+}
+
+func (w *wrapper) compute(data []byte) ([]byte, error) {
+	mac, err := w.cfg.Compute(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute MAC: %s", err.Error())
+	}
+	if len(mac) < w.cfg.Size {
+		return nil, fmt.Errorf("cannot compute MAC: MAC is shorter than %d bytes", w.cfg.Size)
+	}
+	return mac[:w.cfg.Size], nil
+}
+
+// macData return the bytes covered by the MAC, checking that encoded end with value of MAC field
+func (w *wrapper) macData(encoded []byte, value string) ([]byte, error) {
+	if len(encoded) < w.cfg.Skip+len(value) || !bytes.HasSuffix(encoded, []byte(value)) {
+		return nil, fmt.Errorf("MAC field is not encoded verbatim at the end of the message")
+	}
+	return encoded[w.cfg.Skip : len(encoded)-len(value)], nil
+}
+
+func (w *wrapper) afterEncode(msg spec.Msg, encoded []byte) ([]byte, error) {
+	placeholder := w.placeholder()
+	data, err := w.macData(encoded, placeholder)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := w.compute(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]byte, 0, len(encoded))
+	ret = append(ret, encoded[:len(encoded)-len(placeholder)]...)
+	ret = append(ret, w.encodeValue(mac)...)
+	return ret, nil
+}
+
+func (w *wrapper) afterDecode(msg spec.Msg, raw []byte) (spec.Msg, error) {
+	if w.cfg.SkipVerify {
+		return msg, nil
+	}
+
+	value, ok := msg[w.field(msg)]
+	if !ok {
+		return nil, ErrMissingMAC
+	}
+
+	data, err := w.macData(raw, value)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := w.compute(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !Equal([]byte(value), []byte(w.encodeValue(expected))) {
+		return nil, ErrInvalidMAC
+	}
+
+	return msg, nil
+}
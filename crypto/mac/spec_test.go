@@ -0,0 +1,79 @@
+package mac_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/mac"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func newTestSpec() spec.Spec {
+	return mac.Wrap(&spectest.RefSpec{}, mac.Config{
+		Compute:  mac.WithKey(mac.ISO9797Alg3, mac.StaticKey(unhex("0123456789ABCDEF FEDCBA9876543210"))),
+		Encoding: mac.EncodingHex,
+		Skip:     4,
+	})
+}
+
+func TestWrapConformance(t *testing.T) {
+	spectest.Run(t, newTestSpec(), spectest.Config{
+		Samples: []spec.Msg{
+			{0: "0200", 2: "4111111111111111", 3: "000000", 4: "000000010000", 11: "000001", 41: "TERM0001"},
+			{0: "0200", 3: "000000", 11: "000001", 41: "TERM0001", 102: "1234567890"},
+		},
+		IgnoreFields: []int{64, 128},
+	})
+}
+
+func TestWrapField(t *testing.T) {
+	s := newTestSpec()
+	for _, tc := range []struct {
+		msg   spec.Msg
+		field int
+	}{
+		{spec.Msg{0: "0200", 11: "000001"}, 64},
+		{spec.Msg{0: "0200", 11: "000001", 102: "1234567890"}, 128},
+		{spec.Msg{0: "0200", 11: "000001", 64: "stale"}, 64},
+	} {
+		encoded, err := s.MsgEncode(tc.msg)
+		if err != nil {
+			t.Fatalf("invalid err: %s", err.Error())
+		}
+		_, decoded, _, err := s.MsgDecode(encoded)
+		if err != nil {
+			t.Fatalf("invalid err: %s", err.Error())
+		}
+		if len(decoded[tc.field]) != 16 {
+			t.Fatalf("invalid MAC field: %v", decoded)
+		}
+		if tc.field == 128 {
+			if _, ok := decoded[64]; ok {
+				t.Fatalf("field 64 must not be present with secondary bitmap")
+			}
+		}
+	}
+}
+
+func TestWrapVerify(t *testing.T) {
+	s := newTestSpec()
+	encoded, err := s.MsgEncode(spec.Msg{0: "0200", 4: "000000010000", 11: "000001"})
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+
+	// tamper amount
+	tampered := append([]byte(nil), encoded...)
+	tampered[bytes.Index(tampered, []byte("000000010000"))+6] = '9'
+	if _, _, _, err := s.MsgDecode(tampered); !errors.Is(err, mac.ErrInvalidMAC) {
+		t.Fatalf("invalid err: %v", err)
+	}
+
+	// message without MAC
+	plain, _ := (&spectest.RefSpec{}).MsgEncode(spec.Msg{0: "0200", 11: "000001"})
+	if _, _, _, err := s.MsgDecode(plain); !errors.Is(err, mac.ErrMissingMAC) {
+		t.Fatalf("invalid err: %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+// Package pinblock implement ISO 9564-1 PIN block format 0, 1, 3 (TDES) and 4 (AES).
+package pinblock
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+)
+
+// Format of PIN block.
+type Format int
+
+// Format values
+const (
+	Format0 Format = 0
+	Format1 Format = 1
+	Format3 Format = 3
+	Format4 Format = 4
+)
+
+// ErrInvalidPIN .
+var ErrInvalidPIN = fmt.Errorf("invalid PIN")
+
+// ErrInvalidPAN .
+var ErrInvalidPAN = fmt.Errorf("invalid PAN")
+
+// ErrInvalidPINBlock .
+var ErrInvalidPINBlock = fmt.Errorf("invalid PIN block")
+
+// ErrUnsupportedFormat .
+var ErrUnsupportedFormat = fmt.Errorf("unsupported PIN block format")
+
+var randReader io.Reader = rand.Reader
+
+// Clear build clear PIN block of format 0, 1 or 3 (8 bytes).
+// pan is not used by format 1.
+// Format 4 doesn't have clear PIN block, use Encrypt instead.
+func Clear(format Format, pin, pan string) ([]byte, error) {
+	if format != Format0 && format != Format1 && format != Format3 {
+		return nil, ErrUnsupportedFormat
+	}
+	if !validPIN(pin) {
+		return nil, ErrInvalidPIN
+	}
+
+	nibbles := make([]byte, 16)
+	nibbles[0] = byte(format)
+	nibbles[1] = byte(len(pin))
+	for i := 0; i < len(pin); i++ {
+		nibbles[2+i] = pin[i] - '0'
+	}
+
+	fill := make([]byte, 14-len(pin))
+	if format != Format0 {
+		if _, err := io.ReadFull(randReader, fill); err != nil {
+			return nil, err
+		}
+	}
+	for i := range fill {
+		switch format {
+		case Format0:
+			nibbles[2+len(pin)+i] = 0xF
+		case Format1:
+			nibbles[2+len(pin)+i] = fill[i] & 0xF
+		case Format3:
+			nibbles[2+len(pin)+i] = 0xA + fill[i]%6
+		}
+	}
+
+	block := packNibbles(nibbles)
+
+	if format == Format1 {
+		return block, nil
+	}
+
+	panField, err := panField03(pan)
+	if err != nil {
+		return nil, err
+	}
+	xorBytes(block, block, panField)
+	return block, nil
+}
+
+// ParseClear extract PIN from clear PIN block of format 0, 1 or 3.
+func ParseClear(format Format, block []byte, pan string) (string, error) {
+	if format != Format0 && format != Format1 && format != Format3 {
+		return "", ErrUnsupportedFormat
+	}
+	if len(block) != 8 {
+		return "", ErrInvalidPINBlock
+	}
+
+	block = append([]byte(nil), block...)
+	if format != Format1 {
+		panField, err := panField03(pan)
+		if err != nil {
+			return "", err
+		}
+		xorBytes(block, block, panField)
+	}
+
+	nibbles := unpackNibbles(block)
+	if nibbles[0] != byte(format) {
+		return "", ErrInvalidPINBlock
+	}
+	pin, rest, err := parsePINNibbles(nibbles[1:])
+	if err != nil {
+		return "", err
+	}
+	for _, n := range rest {
+		switch {
+		case format == Format0 && n != 0xF:
+			return "", ErrInvalidPINBlock
+		case format == Format3 && n < 0xA:
+			return "", ErrInvalidPINBlock
+		}
+	}
+	return pin, nil
+}
+
+// Encrypt build PIN block and encrypt it with key named keyName in kp.
+// Format 0, 1 and 3 need TDES key, format 4 need AES key.
+func Encrypt(kp keys.Provider, keyName string, format Format, pin, pan string) ([]byte, error) {
+	if format == Format4 {
+		return encrypt4(kp, keyName, pin, pan)
+	}
+
+	block, err := Clear(format, pin, pan)
+	if err != nil {
+		return nil, err
+	}
+	return kp.EncryptBlock(keyName, block)
+}
+
+// Decrypt decrypt PIN block with key named keyName in kp and extract the PIN.
+func Decrypt(kp keys.Provider, keyName string, format Format, block []byte, pan string) (string, error) {
+	if format == Format4 {
+		return decrypt4(kp, keyName, block, pan)
+	}
+
+	if len(block) != 8 {
+		return "", ErrInvalidPINBlock
+	}
+	clear, err := kp.DecryptBlock(keyName, block)
+	if err != nil {
+		return "", err
+	}
+	return ParseClear(format, clear, pan)
+}
+
+// Translator is optionally implemented by keys.Provider that can translate PIN block internally, e.g. HSM.
+type Translator interface {
+	TranslatePINBlock(block []byte, pan string, inKey string, inFormat Format, outKey string, outFormat Format) ([]byte, error)
+}
+
+// Translate decrypt PIN block with inKey and re-encrypt it with outKey, possibly in different format.
+// It is delegated to kp if it implements Translator, otherwise the clear PIN is recovered in memory.
+func Translate(kp keys.Provider, block []byte, pan string, inKey string, inFormat Format, outKey string, outFormat Format) ([]byte, error) {
+	if t, ok := kp.(Translator); ok {
+		return t.TranslatePINBlock(block, pan, inKey, inFormat, outKey, outFormat)
+	}
+
+	pin, err := Decrypt(kp, inKey, inFormat, block, pan)
+	if err != nil {
+		return nil, err
+	}
+	return Encrypt(kp, outKey, outFormat, pin, pan)
+}
+
+func encrypt4(kp keys.Provider, keyName string, pin, pan string) ([]byte, error) {
+	if !validPIN(pin) {
+		return nil, ErrInvalidPIN
+	}
+	panField, err := panField4(pan)
+	if err != nil {
+		return nil, err
+	}
+
+	nibbles := make([]byte, 32)
+	nibbles[0] = 4
+	nibbles[1] = byte(len(pin))
+	for i := 0; i < 14; i++ {
+		if i < len(pin) {
+			nibbles[2+i] = pin[i] - '0'
+		} else {
+			nibbles[2+i] = 0xA
+		}
+	}
+	random := make([]byte, 16)
+	if _, err := io.ReadFull(randReader, random); err != nil {
+		return nil, err
+	}
+	for i := 16; i < 32; i++ {
+		nibbles[i] = random[i-16] & 0xF
+	}
+
+	intermediate, err := kp.EncryptBlock(keyName, packNibbles(nibbles))
+	if err != nil {
+		return nil, err
+	}
+	xorBytes(intermediate, intermediate, panField)
+	return kp.EncryptBlock(keyName, intermediate)
+}
+
+func decrypt4(kp keys.Provider, keyName string, block []byte, pan string) (string, error) {
+	if len(block) != 16 {
+		return "", ErrInvalidPINBlock
+	}
+	panField, err := panField4(pan)
+	if err != nil {
+		return "", err
+	}
+
+	intermediate, err := kp.DecryptBlock(keyName, block)
+	if err != nil {
+		return "", err
+	}
+	xorBytes(intermediate, intermediate, panField)
+	pinField, err := kp.DecryptBlock(keyName, intermediate)
+	if err != nil {
+		return "", err
+	}
+
+	nibbles := unpackNibbles(pinField)
+	if nibbles[0] != 4 {
+		return "", ErrInvalidPINBlock
+	}
+	pin, rest, err := parsePINNibbles(nibbles[1:16])
+	if err != nil {
+		return "", err
+	}
+	for _, n := range rest {
+		if n != 0xA {
+			return "", ErrInvalidPINBlock
+		}
+	}
+	return pin, nil
+}
+
+// panField03 return PAN field for format 0 and 3: 4 zero nibbles and 12 rightmost PAN digits excluding the check digit
+func panField03(pan string) ([]byte, error) {
+	if len(pan) < 2 || !validDigits(pan) {
+		return nil, ErrInvalidPAN
+	}
+	digits := pan[:len(pan)-1]
+	if len(digits) > 12 {
+		digits = digits[len(digits)-12:]
+	}
+
+	nibbles := make([]byte, 16)
+	for i := 0; i < len(digits); i++ {
+		nibbles[16-len(digits)+i] = digits[i] - '0'
+	}
+	return packNibbles(nibbles), nil
+}
+
+// panField4 return PAN field for format 4: PAN length indicator (length - 12), PAN (left padded to 12 digits), right padded with zero
+func panField4(pan string) ([]byte, error) {
+	if len(pan) == 0 || len(pan) > 19 || !validDigits(pan) {
+		return nil, ErrInvalidPAN
+	}
+
+	m := 0
+	if len(pan) > 12 {
+		m = len(pan) - 12
+	}
+
+	nibbles := make([]byte, 32)
+	nibbles[0] = byte(m)
+	offset := 1 + (12 - len(pan) + m)
+	for i := 0; i < len(pan); i++ {
+		nibbles[offset+i] = pan[i] - '0'
+	}
+	return packNibbles(nibbles), nil
+}
+
+// parsePINNibbles parse PIN length nibble followed by PIN digits, returning the remaining nibbles
+func parsePINNibbles(nibbles []byte) (string, []byte, error) {
+	n := int(nibbles[0])
+	if n < 4 || n > 12 || n > len(nibbles)-1 {
+		return "", nil, ErrInvalidPINBlock
+	}
+	pin := make([]byte, n)
+	for i := 0; i < n; i++ {
+		d := nibbles[1+i]
+		if d > 9 {
+			return "", nil, ErrInvalidPINBlock
+		}
+		pin[i] = '0' + d
+	}
+	return string(pin), nibbles[1+n:], nil
+}
+
+func validPIN(pin string) bool {
+	return 4 <= len(pin) && len(pin) <= 12 && validDigits(pin)
+}
+
+func validDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func packNibbles(nibbles []byte) []byte {
+	ret := make([]byte, len(nibbles)/2)
+	for i := range ret {
+		ret[i] = nibbles[2*i]<<4 | nibbles[2*i+1]
+	}
+	return ret
+}
+
+func unpackNibbles(b []byte) []byte {
+	ret := make([]byte, len(b)*2)
+	for i, x := range b {
+		ret[2*i] = x >> 4
+		ret[2*i+1] = x & 0xF
+	}
+	return ret
+}
+
+func xorBytes(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
@@ -0,0 +1,203 @@
+package pinblock_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+	"github.com/payfazz/iso8585-utility-lib/crypto/pinblock"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func testProvider() keys.Static {
+	return keys.Static{
+		"tpk": {Type: keys.TDES, Value: mustHex("0123456789ABCDEFFEDCBA9876543210")},
+		"zpk": {Type: keys.TDES, Value: mustHex("89ABCDEF0123456776543210FEDCBA98")},
+		"aes": {Type: keys.AES, Value: mustHex("2B7E151628AED2A6ABF7158809CF4F3C")},
+	}
+}
+
+func TestClearFormat0(t *testing.T) {
+	block, err := pinblock.Clear(pinblock.Format0, "1234", "4111111111111111")
+	if err != nil {
+		t.Fatalf("invalid Clear: %s", err.Error())
+	}
+	if got := strings.ToUpper(hex.EncodeToString(block)); got != "041225EEEEEEEEEE" {
+		t.Fatalf("invalid format 0 block: %s", got)
+	}
+
+	pin, err := pinblock.ParseClear(pinblock.Format0, block, "4111111111111111")
+	if err != nil || pin != "1234" {
+		t.Fatalf("invalid ParseClear: %q %v", pin, err)
+	}
+
+	if _, err := pinblock.ParseClear(pinblock.Format0, block, "4111111111111112"); err != nil {
+		t.Fatalf("check digit must not be part of PAN field")
+	}
+	if _, err := pinblock.ParseClear(pinblock.Format0, block, "5111111111111111"); err != nil {
+		t.Fatalf("only 12 rightmost digits must be part of PAN field")
+	}
+	if _, err := pinblock.ParseClear(pinblock.Format0, block, "4111111111111121"); err == nil {
+		t.Fatalf("ParseClear with different PAN must fail")
+	}
+}
+
+func TestClearFormat1And3(t *testing.T) {
+	for _, format := range []pinblock.Format{pinblock.Format1, pinblock.Format3} {
+		a, err := pinblock.Clear(format, "123456", "4111111111111111")
+		if err != nil {
+			t.Fatalf("invalid Clear format %d: %s", format, err.Error())
+		}
+		b, _ := pinblock.Clear(format, "123456", "4111111111111111")
+		if bytes.Equal(a, b) {
+			t.Fatalf("format %d must use random fill", format)
+		}
+		pin, err := pinblock.ParseClear(format, a, "4111111111111111")
+		if err != nil || pin != "123456" {
+			t.Fatalf("invalid ParseClear format %d: %q %v", format, pin, err)
+		}
+	}
+}
+
+func TestClearInvalid(t *testing.T) {
+	if _, err := pinblock.Clear(pinblock.Format0, "123", "4111111111111111"); err != pinblock.ErrInvalidPIN {
+		t.Fatalf("invalid error for short PIN: %v", err)
+	}
+	if _, err := pinblock.Clear(pinblock.Format0, "12a4", "4111111111111111"); err != pinblock.ErrInvalidPIN {
+		t.Fatalf("invalid error for non digit PIN: %v", err)
+	}
+	if _, err := pinblock.Clear(pinblock.Format0, "1234", "41111x1111111111"); err != pinblock.ErrInvalidPAN {
+		t.Fatalf("invalid error for non digit PAN: %v", err)
+	}
+	if _, err := pinblock.Clear(pinblock.Format4, "1234", "4111111111111111"); err != pinblock.ErrUnsupportedFormat {
+		t.Fatalf("invalid error for format 4: %v", err)
+	}
+	if _, err := pinblock.ParseClear(pinblock.Format3, mustHex("041225EEEEEEEEEE"), "4111111111111111"); err != pinblock.ErrInvalidPINBlock {
+		t.Fatalf("invalid error for wrong format: %v", err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	kp := testProvider()
+	pan := "4111111111111111"
+
+	cases := []struct {
+		format pinblock.Format
+		key    string
+		size   int
+	}{
+		{pinblock.Format0, "tpk", 8},
+		{pinblock.Format1, "tpk", 8},
+		{pinblock.Format3, "tpk", 8},
+		{pinblock.Format4, "aes", 16},
+	}
+	for _, c := range cases {
+		for _, pin := range []string{"1234", "987654", "123456789012"} {
+			block, err := pinblock.Encrypt(kp, c.key, c.format, pin, pan)
+			if err != nil {
+				t.Fatalf("invalid Encrypt format %d: %s", c.format, err.Error())
+			}
+			if len(block) != c.size {
+				t.Fatalf("invalid block size for format %d: %d", c.format, len(block))
+			}
+			got, err := pinblock.Decrypt(kp, c.key, c.format, block, pan)
+			if err != nil || got != pin {
+				t.Fatalf("invalid Decrypt format %d: %q %v", c.format, got, err)
+			}
+		}
+	}
+
+	block, err := pinblock.Encrypt(kp, "tpk", pinblock.Format0, "1234", pan)
+	if err != nil {
+		t.Fatalf("invalid Encrypt: %s", err.Error())
+	}
+	expected, _ := kp["tpk"].EncryptECB(mustHex("041225EEEEEEEEEE"))
+	if !bytes.Equal(block, expected) {
+		t.Fatalf("format 0 block must be encrypted in ECB mode")
+	}
+
+	if _, err := pinblock.Encrypt(kp, "unknown", pinblock.Format0, "1234", pan); err != keys.ErrKeyNotFound {
+		t.Fatalf("invalid error for unknown key: %v", err)
+	}
+}
+
+func TestFormat4PAN(t *testing.T) {
+	kp := testProvider()
+	for _, pan := range []string{"411111111111", "4111111111111111", "4111111111111111111", "12345"} {
+		block, err := pinblock.Encrypt(kp, "aes", pinblock.Format4, "1234", pan)
+		if err != nil {
+			t.Fatalf("invalid Encrypt with PAN %s: %s", pan, err.Error())
+		}
+		if pin, err := pinblock.Decrypt(kp, "aes", pinblock.Format4, block, pan); err != nil || pin != "1234" {
+			t.Fatalf("invalid Decrypt with PAN %s: %q %v", pan, pin, err)
+		}
+		if _, err := pinblock.Decrypt(kp, "aes", pinblock.Format4, block, pan+"0"); err == nil {
+			t.Fatalf("Decrypt with different PAN must fail")
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	kp := testProvider()
+	pan := "5413330089604111"
+
+	block, err := pinblock.Encrypt(kp, "tpk", pinblock.Format1, "4321", pan)
+	if err != nil {
+		t.Fatalf("invalid Encrypt: %s", err.Error())
+	}
+
+	zone, err := pinblock.Translate(kp, block, pan, "tpk", pinblock.Format1, "zpk", pinblock.Format0)
+	if err != nil {
+		t.Fatalf("invalid Translate: %s", err.Error())
+	}
+	if pin, err := pinblock.Decrypt(kp, "zpk", pinblock.Format0, zone, pan); err != nil || pin != "4321" {
+		t.Fatalf("invalid translated block: %q %v", pin, err)
+	}
+
+	aes, err := pinblock.Translate(kp, zone, pan, "zpk", pinblock.Format0, "aes", pinblock.Format4)
+	if err != nil {
+		t.Fatalf("invalid Translate to format 4: %s", err.Error())
+	}
+	if pin, err := pinblock.Decrypt(kp, "aes", pinblock.Format4, aes, pan); err != nil || pin != "4321" {
+		t.Fatalf("invalid translated format 4 block: %q %v", pin, err)
+	}
+
+	if _, err := pinblock.Translate(kp, zone, pan, "tpk", pinblock.Format0, "zpk", pinblock.Format0); err == nil {
+		t.Fatalf("Translate with wrong input key must fail")
+	}
+}
+
+type translator struct {
+	keys.Static
+	called bool
+}
+
+func (t *translator) TranslatePINBlock(block []byte, pan string, inKey string, inFormat pinblock.Format, outKey string, outFormat pinblock.Format) ([]byte, error) {
+	t.called = true
+	return []byte("translated"), nil
+}
+
+func (t *translator) DecryptBlock(name string, data []byte) ([]byte, error) {
+	panic("the PIN must not be decrypted by Translate")
+}
+
+func TestTranslateDelegate(t *testing.T) {
+	kp := &translator{Static: testProvider()}
+	block, err := pinblock.Encrypt(kp, "tpk", pinblock.Format0, "1234", "4111111111111111")
+	if err != nil {
+		t.Fatalf("invalid Encrypt: %s", err.Error())
+	}
+	out, err := pinblock.Translate(kp, block, "4111111111111111", "tpk", pinblock.Format0, "zpk", pinblock.Format0)
+	if err != nil || string(out) != "translated" || !kp.called {
+		t.Fatalf("Translate must be delegated to Translator: %q %v", out, err)
+	}
+}
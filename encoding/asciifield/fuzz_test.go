@@ -0,0 +1,85 @@
+package asciifield_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/encoding/asciifield"
+)
+
+func fuzzFields() map[string]asciifield.Field {
+	return map[string]asciifield.Field{
+		"fix4":   asciifield.FixSize(4),
+		"fix12":  asciifield.FixSize(12),
+		"lvar":   asciifield.LVar(),
+		"llvar":  asciifield.LLVar(),
+		"lllvar": asciifield.LLLVar(),
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte("0200"))
+	f.Add([]byte("000000010000"))
+	f.Add([]byte("164111111111111111"))
+	f.Add([]byte("0374111111111111111=28121011234567890"))
+	f.Add([]byte("999"))
+	f.Add([]byte("00"))
+	f.Add([]byte("-1a"))
+	f.Add([]byte("\x00\xff\n"))
+
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		for name, field := range fuzzFields() {
+			input := append([]byte(nil), encoded...)
+			advance, decoded, needMore, err := field.Decode(input)
+			if !bytes.Equal(input, encoded) {
+				t.Fatalf("%s: Decode modify its input", name)
+			}
+			if err != nil {
+				continue
+			}
+			if needMore > 0 {
+				if advance != 0 || decoded != "" {
+					t.Fatalf("%s: Decode returning data with needMore", name)
+				}
+				continue
+			}
+			if needMore < 0 || advance <= 0 || advance > len(encoded) {
+				t.Fatalf("%s: invalid advance %d needMore %d for %d bytes", name, advance, needMore, len(encoded))
+			}
+
+			// decoded value must be encoded back to the same bytes
+			reencoded, err := field.Encode(decoded)
+			if err != nil {
+				t.Fatalf("%s: Encode error on decoded value: %s", name, err.Error())
+			}
+			if !bytes.Equal(reencoded, encoded[:advance]) {
+				t.Fatalf("%s: Encode(Decode(x)) != x: %q != %q", name, reencoded, encoded[:advance])
+			}
+		}
+	})
+}
+
+func FuzzEncode(f *testing.F) {
+	f.Add("0200")
+	f.Add("4111111111111111")
+	f.Add("TERM0001")
+	f.Add("")
+	f.Add("abc\n")
+	f.Add("été")
+
+	f.Fuzz(func(t *testing.T, decoded string) {
+		for name, field := range fuzzFields() {
+			encoded, err := field.Encode(decoded)
+			if err != nil {
+				continue
+			}
+			advance, output, needMore, err := field.Decode(encoded)
+			if err != nil {
+				t.Fatalf("%s: Decode error on encoded value: %s", name, err.Error())
+			}
+			if needMore != 0 || advance != len(encoded) || output != decoded {
+				t.Fatalf("%s: Decode(Encode(x)) != x", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package upstream
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff return the delay before reconnect attempt,
+// attempt start from 1 for the first reconnect after a stable connection, see Builder.WithBackoff.
+type Backoff func(attempt int) time.Duration
+
+const (
+	defaultBackoffDelay  = 500 * time.Millisecond
+	defaultBackoffStable = 30 * time.Second
+)
+
+// ConstantBackoff .
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(int) time.Duration {
+		return delay
+	}
+}
+
+// ExponentialBackoff return Backoff that start from base and doubled on every attempt, up to max.
+// jitter (0 to 1) is the fraction of the delay that is randomized,
+// e.g. 0.5 means the delay is between 50% and 100% of the computed value.
+func ExponentialBackoff(base, max time.Duration, jitter float64) Backoff {
+	if jitter < 0 {
+		jitter = 0
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return func(attempt int) time.Duration {
+		delay := base
+		for i := 1; i < attempt && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		if jitter > 0 {
+			delay -= time.Duration(jitter * rand.Float64() * float64(delay))
+		}
+		return delay
+	}
+}
+
+// breaker is circuit breaker that open after threshold consecutive failed connection attempts,
+// and closed when a connection is ready
+type breaker struct {
+	threshold int
+
+	lock     sync.Mutex
+	failures int
+	since    time.Time
+	lastErr  error
+}
+
+// failed record failed connection attempt, it returns true if the breaker is just opened
+func (b *breaker) failed(err error) bool {
+	if b.threshold <= 0 {
+		return false
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures++
+	b.lastErr = err
+	if b.failures == b.threshold {
+		b.since = time.Now()
+		return true
+	}
+	return false
+}
+
+// succeeded record ready connection, it returns true if the breaker was open
+func (b *breaker) succeeded() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	wasOpen := b.failures >= b.threshold
+	b.failures = 0
+	b.lastErr = nil
+	return wasOpen
+}
+
+// check return *ErrUpstreamUnavailable if the breaker is open
+func (b *breaker) check() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	return &ErrUpstreamUnavailable{Since: b.since, LastErr: b.lastErr}
+}
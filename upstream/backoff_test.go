@@ -0,0 +1,167 @@
+package upstream_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := upstream.ExponentialBackoff(100*time.Millisecond, time.Second, 0)
+	for _, tc := range []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	} {
+		if got := b(tc.attempt); got != tc.expected {
+			t.Fatalf("invalid delay of attempt %d: %s", tc.attempt, got)
+		}
+	}
+}
+
+func TestExponentialBackoffJitter(t *testing.T) {
+	for _, tc := range []struct {
+		jitter float64
+		min    time.Duration
+	}{
+		{0.5, 500 * time.Millisecond},
+		{2, 0},
+	} {
+		b := upstream.ExponentialBackoff(time.Second, time.Second, tc.jitter)
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 100; i++ {
+			got := b(1)
+			if got < tc.min || got > time.Second {
+				t.Fatalf("invalid delay with jitter %v: %s", tc.jitter, got)
+			}
+			seen[got] = true
+		}
+		if len(seen) < 2 {
+			t.Fatalf("delay with jitter %v must be randomized", tc.jitter)
+		}
+	}
+}
+
+// attemptRecorder is Backoff that record the attempt numbers
+type attemptRecorder struct {
+	lock     sync.Mutex
+	attempts []int
+}
+
+func (r *attemptRecorder) backoff(attempt int) time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.attempts = append(r.attempts, attempt)
+	return 10 * time.Millisecond
+}
+
+func (r *attemptRecorder) first(n int) []int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.attempts) < n {
+		return nil
+	}
+	return append([]int(nil), r.attempts[:n]...)
+}
+
+func TestBackoffReset(t *testing.T) {
+	// accept connection and close it after 100ms
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("invalid listen: %s", err.Error())
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			time.AfterFunc(100*time.Millisecond, func() { conn.Close() })
+		}
+	}()
+
+	for _, tc := range []struct {
+		name     string
+		addr     string
+		stable   time.Duration
+		expected []int
+	}{
+		{"never ready", closedHost(t), time.Millisecond, []int{1, 2, 3}},
+		{"not stable", ln.Addr().String(), time.Hour, []int{1, 2, 3}},
+		{"stable", ln.Addr().String(), 50 * time.Millisecond, []int{1, 1, 1}},
+	} {
+		r := &attemptRecorder{}
+		u := build(t, upstream.NewBuilder().
+			WithTarget(tc.addr).
+			WithSpec(&spectest.RefSpec{}).
+			WithBackoff(r.backoff, tc.stable))
+		waitFor(t, tc.name+" attempts", func() bool { return r.first(3) != nil })
+		u.Close()
+
+		if got := r.first(3); !equalInts(got, tc.expected) {
+			t.Fatalf("%s: invalid attempts: %v", tc.name, got)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	addr := closedHost(t)
+	u := build(t, upstream.NewBuilder().
+		WithTarget(addr).
+		WithSpec(&spectest.RefSpec{}).
+		WithBackoff(upstream.ConstantBackoff(20*time.Millisecond), 0).
+		WithCircuitBreaker(3))
+
+	// open after 3 failed attempts, Process fail fast
+	var unavailable *upstream.ErrUpstreamUnavailable
+	waitFor(t, "breaker opened", func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := u.Process(ctx, request("000001"))
+		return errors.As(err, &unavailable)
+	})
+	if unavailable.LastErr == nil || unavailable.Since.IsZero() {
+		t.Fatalf("invalid ErrUpstreamUnavailable: %+v", unavailable)
+	}
+	start := time.Now()
+	if _, err := process(u, request("000001")); !errors.As(err, &unavailable) {
+		t.Fatalf("invalid err: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Process must fail fast when the breaker is open")
+	}
+
+	// reconnect attempts continue while open, and the breaker is closed when the host is back
+	newTestHostAt(t, addr, nil, approve)
+	waitFor(t, "breaker closed", func() bool {
+		_, err := process(u, request("000002"))
+		return err == nil
+	})
+}
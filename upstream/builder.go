@@ -1,24 +1,55 @@
 package upstream
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net/url"
+	"os"
+	"time"
 
+	"github.com/payfazz/mainutil/maintls"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/seq"
 	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
 )
 
+const (
+	defaultDialTimeout      = 5 * time.Second
+	defaultOnNewConnTimeout = 10 * time.Second
+	defaultMinPingInterval  = 5 * time.Second
+	defaultWriteTimeout     = 10 * time.Second
+)
+
 // Builder .
 type Builder struct {
 	inner *Upstream
+	err   error
 }
 
 // NewBuilder .
 func NewBuilder() *Builder {
-	return &Builder{inner: &Upstream{}}
+	return &Builder{inner: &Upstream{
+		readLimits: spec.DefaultLimits,
+	}}
 }
 
 // WithTarget .
 func (b *Builder) WithTarget(target string) *Builder {
-	b.inner.target = target
+	return b.WithTargets(PolicyFailover, Target{Addr: target})
+}
+
+// WithTargets set the targets to connect to and the policy to choose between them,
+// see Upstream.ConnectedTargets for the currently connected targets.
+func (b *Builder) WithTargets(policy TargetPolicy, targets ...Target) *Builder {
+	b.inner.targets.config = append([]Target(nil), targets...)
+	b.inner.targets.policy = policy
+	return b
+}
+
+// WithFailBack set the period to probe higher priority targets when PolicyFailover is used, default to 5 minutes.
+func (b *Builder) WithFailBack(period time.Duration) *Builder {
+	b.inner.targets.failBack = period
 	return b
 }
 
@@ -28,6 +59,17 @@ func (b *Builder) WithSpec(spec spec.Spec) *Builder {
 	return b
 }
 
+// WithSpecName use spec registered under name in the spec registry, created with cfg, see spec.New.
+func (b *Builder) WithSpecName(name string, cfg map[string]string) *Builder {
+	s, err := spec.New(name, cfg)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.inner.spec = s
+	return b
+}
+
 // WithLogger .
 func (b *Builder) WithLogger(info, err func(string)) *Builder {
 	b.inner.logger.Info = info
@@ -35,9 +77,188 @@ func (b *Builder) WithLogger(info, err func(string)) *Builder {
 	return b
 }
 
-// WithProxy .
+// WithProxy set the default proxy, used by targets without its own proxy.
+// Supported schemes are http and https (CONNECT), and socks5 and socks5h (target host resolved by the proxy),
+// with user info of the url as the credential.
 func (b *Builder) WithProxy(proxy *url.URL, proxyCASum string) *Builder {
 	b.inner.proxy.endpoint = proxy
 	b.inner.proxy.caSum = proxyCASum
 	return b
 }
+
+// WithTLS wrap the connection to the targets in TLS (inside the proxy tunnel, if any),
+// config can be nil to use the default config (TLS 1.2+ with system root CAs).
+// When caSum is not empty, the CA of the target certificate is pinned, like in WithProxy.
+func (b *Builder) WithTLS(config *tls.Config, caSum string) *Builder {
+	b.inner.targetTLS.enabled = true
+	b.inner.targetTLS.config = config
+	b.inner.targetTLS.caSum = caSum
+	return b
+}
+
+// WithTLSFiles is like WithTLS, with client certificate loaded from certFile and keyFile,
+// and root CAs loaded from caFile (PEM), any of them can be empty.
+func (b *Builder) WithTLSFiles(certFile, keyFile, caFile string, caSum string) *Builder {
+	config := maintls.TLSConfig()
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			b.err = fmt.Errorf("cannot load TLS client certificate: %s", err.Error())
+			return b
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			b.err = fmt.Errorf("cannot load TLS CA: %s", err.Error())
+			return b
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			b.err = fmt.Errorf("cannot load TLS CA: no certificate found in %s", caFile)
+			return b
+		}
+		config.RootCAs = pool
+	}
+
+	return b.WithTLS(config, caSum)
+}
+
+// WithSTAN make Process fill field 11 using gen when the message doesn't have it,
+// STAN that collide with in-flight request is skipped.
+// Field 11 of network management messages (see NetworkMgmtFunc) is always filled, from in-memory STAN if gen is not set.
+func (b *Builder) WithSTAN(gen *seq.STAN) *Builder {
+	b.inner.stan = gen
+	return b
+}
+
+// WithReadLimits set maximum message size and read buffer size, default to spec.DefaultLimits.
+// When the limit is exceeded, the connection is dropped, unless the spec implement spec.Resyncer.
+func (b *Builder) WithReadLimits(limits spec.Limits) *Builder {
+	b.inner.readLimits = limits
+	return b
+}
+
+// WithPool keep size connections to the target, requests are distributed across ready connections according to balance.
+// Network management is done per connection, default to 1 connection.
+func (b *Builder) WithPool(size int, balance Balance) *Builder {
+	b.inner.pool.size = size
+	b.inner.pool.balance = balance
+	return b
+}
+
+// WithBackoff set the delay before reconnect, default to ConstantBackoff(500ms).
+// The attempt counter is reset when the previous connection was ready for at least stable, stable <= 0 means 30 seconds.
+func (b *Builder) WithBackoff(backoff Backoff, stable time.Duration) *Builder {
+	b.inner.reconnect.backoff = backoff
+	b.inner.reconnect.stable = stable
+	return b
+}
+
+// WithCircuitBreaker make Process fail fast with *ErrUpstreamUnavailable when there is no ready connection
+// and the last failures connection attempts failed, instead of waiting for ctx expiry.
+// The breaker is closed as soon as a connection is ready, zero means no circuit breaker.
+func (b *Builder) WithCircuitBreaker(failures int) *Builder {
+	b.inner.breaker.threshold = failures
+	return b
+}
+
+// WithDialTimeout set the timeout to connect to the target (including proxy handshake), default to 5 seconds.
+func (b *Builder) WithDialTimeout(timeout time.Duration) *Builder {
+	b.inner.timeouts.dial = timeout
+	return b
+}
+
+// WithOnNewConnTimeout set the timeout of spec.Spec.OnNewConn, default to 10 seconds.
+func (b *Builder) WithOnNewConnTimeout(timeout time.Duration) *Builder {
+	b.inner.timeouts.onNewConn = timeout
+	return b
+}
+
+// WithMinPingInterval set the minimum interval of ping message from spec.Spec.GetPingMsg, default to 5 seconds.
+// The connection is dropped if nothing is received for one ping interval.
+func (b *Builder) WithMinPingInterval(interval time.Duration) *Builder {
+	b.inner.timeouts.minPing = interval
+	return b
+}
+
+// WithWriteTimeout set the deadline of every write to the connection, default to 10 seconds.
+func (b *Builder) WithWriteTimeout(timeout time.Duration) *Builder {
+	b.inner.timeouts.write = timeout
+	return b
+}
+
+// WithReadTimeout drop the connection if nothing is received for timeout, default to 0 (no read deadline),
+// see also WithMinPingInterval.
+func (b *Builder) WithReadTimeout(timeout time.Duration) *Builder {
+	b.inner.timeouts.read = timeout
+	return b
+}
+
+// WithSignOn run fn after connected, before any request is sent,
+// if it failed, the connection is dropped and reconnected.
+// timeout <= 0 means 30 seconds.
+func (b *Builder) WithSignOn(fn NetworkMgmtFunc, timeout time.Duration) *Builder {
+	b.inner.netMgmt.signOn = fn
+	b.inner.netMgmt.signOnTimeout = timeout
+	return b
+}
+
+// WithSignOff run fn on Close if the connection is signed on.
+// timeout <= 0 means 30 seconds.
+func (b *Builder) WithSignOff(fn NetworkMgmtFunc, timeout time.Duration) *Builder {
+	b.inner.netMgmt.signOff = fn
+	b.inner.netMgmt.signOffTimeout = timeout
+	return b
+}
+
+// WithEcho run fn every interval, if it failed, the connection is dropped and reconnected.
+// It is independent from spec.Spec.GetPingMsg, which doesn't wait for the response.
+func (b *Builder) WithEcho(fn NetworkMgmtFunc, interval time.Duration) *Builder {
+	b.inner.netMgmt.echo = fn
+	b.inner.netMgmt.echoInterval = interval
+	return b
+}
+
+// WithCutoverHandler call fn when the host send cutover notification (0800 with field 70 = 201),
+// the notification is responded with 0810 if the spec doesn't auto respond to it.
+func (b *Builder) WithCutoverHandler(fn func(msg spec.Msg)) *Builder {
+	b.inner.netMgmt.onCutover = fn
+	return b
+}
+
+// WithKeyExchange run fn after sign on and when the host send key change request (0800 with field 70 = 161),
+// the resulting working keys are kept per connection, see Upstream.WorkingKeys and spec.KeyAware.
+// If it failed after sign on, the connection is dropped and reconnected.
+// timeout <= 0 means 30 seconds.
+func (b *Builder) WithKeyExchange(fn KeyExchangeFunc, timeout time.Duration) *Builder {
+	b.inner.keyExchange.fn = fn
+	b.inner.keyExchange.timeout = timeout
+	return b
+}
+
+// initTimeouts validate the timeouts and fill the defaults
+func (u *Upstream) initTimeouts() error {
+	for _, t := range []struct {
+		name  string
+		value *time.Duration
+		def   time.Duration
+	}{
+		{"dial timeout", &u.timeouts.dial, defaultDialTimeout},
+		{"OnNewConn timeout", &u.timeouts.onNewConn, defaultOnNewConnTimeout},
+		{"min ping interval", &u.timeouts.minPing, defaultMinPingInterval},
+		{"write timeout", &u.timeouts.write, defaultWriteTimeout},
+		{"read timeout", &u.timeouts.read, 0},
+	} {
+		if *t.value < 0 {
+			return fmt.Errorf("invalid %s", t.name)
+		}
+		if *t.value == 0 {
+			*t.value = t.def
+		}
+	}
+	return nil
+}
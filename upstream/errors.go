@@ -1,6 +1,9 @@
 package upstream
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // ErrServerClosed .
 var ErrServerClosed = fmt.Errorf("Upstream already closed")
@@ -13,3 +16,30 @@ type ErrInvalidRequest struct {
 func (e *ErrInvalidRequest) Error() string {
 	return e.Cause.Error()
 }
+
+// Unwrap .
+func (e *ErrInvalidRequest) Unwrap() error {
+	return e.Cause
+}
+
+// ErrUpstreamUnavailable is returned by Process when the circuit breaker is open,
+// see Builder.WithCircuitBreaker.
+type ErrUpstreamUnavailable struct {
+	// Since is the time the breaker is opened
+	Since time.Time
+
+	// LastErr is the error of the last connection attempt
+	LastErr error
+}
+
+func (e *ErrUpstreamUnavailable) Error() string {
+	if e.LastErr == nil {
+		return "upstream unavailable"
+	}
+	return "upstream unavailable: " + e.LastErr.Error()
+}
+
+// Unwrap .
+func (e *ErrUpstreamUnavailable) Unwrap() error {
+	return e.LastErr
+}
@@ -0,0 +1,58 @@
+package upstream_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/fieldenc"
+	"github.com/payfazz/iso8585-utility-lib/crypto/keys"
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func TestProcessInvalidCiphertext(t *testing.T) {
+	// the host echo the encrypted PAN, and corrupt it when field 48 is "BAD"
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		res := approve(hc, msg)
+		res[2], res[62] = msg[2], msg[62]
+		if msg[48] == "BAD" {
+			res[2] = res[2][:len(res[2])-2] + "00"
+		}
+		return res
+	})
+
+	key, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+	s := fieldenc.Wrap(&spectest.RefSpec{}, fieldenc.Config{
+		Key:            fieldenc.StaticKey(keys.Static{"data": {Type: keys.TDES, Value: key}}, "data"),
+		Fields:         []int{2},
+		Encoding:       fieldenc.EncodingHex,
+		IndicatorField: 62,
+	})
+	u := build(t, upstream.NewBuilder().WithTarget(h.addr).WithSpec(s))
+
+	for _, tc := range []struct {
+		mode     string
+		expected error
+	}{
+		{"OK", nil},
+		{"BAD", fieldenc.ErrInvalidCiphertext},
+		{"OK", nil},
+	} {
+		req := request("000001")
+		req[2] = "4111111111111111"
+		req[48] = tc.mode
+		res, err := process(u, req)
+		if !errors.Is(err, tc.expected) {
+			t.Fatalf("%s: invalid err: %v", tc.mode, err)
+		}
+		if err == nil && res[2] != "4111111111111111" {
+			t.Fatalf("%s: invalid response: %v", tc.mode, res)
+		}
+	}
+
+	if h.connCount() != 1 {
+		t.Fatalf("invalid connection count: %d, connection must not be dropped", h.connCount())
+	}
+}
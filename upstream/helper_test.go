@@ -0,0 +1,232 @@
+package upstream_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+// testHost is in-process host, handle is called concurrently for every received message,
+// the returned message (if not nil) is sent back through the same connection.
+type testHost struct {
+	addr   string
+	ln     net.Listener
+	spec   spec.Spec
+	handle func(hc *hostConn, msg spec.Msg) spec.Msg
+
+	lock  sync.Mutex
+	conns []*hostConn
+}
+
+// hostConn is connection accepted by testHost
+type hostConn struct {
+	// index is the order of the connection, start from 0
+	index int
+	conn  net.Conn
+
+	lock sync.Mutex
+	enc  *spec.Encoder
+}
+
+// newTestHost listen on random port, s can be nil to use spectest.RefSpec
+func newTestHost(t *testing.T, s spec.Spec, handle func(hc *hostConn, msg spec.Msg) spec.Msg) *testHost {
+	return newTestHostAt(t, "127.0.0.1:0", s, handle)
+}
+
+// newTestHostAt is newTestHost that listen on addr, e.g. to bring back closed host
+func newTestHostAt(t *testing.T, addr string, s spec.Spec, handle func(hc *hostConn, msg spec.Msg) spec.Msg) *testHost {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("invalid listen: %s", err.Error())
+	}
+	return newTestHostListener(t, ln, s, handle)
+}
+
+// newTestHostListener is newTestHost that serve ln, e.g. TLS listener
+func newTestHostListener(t *testing.T, ln net.Listener, s spec.Spec, handle func(hc *hostConn, msg spec.Msg) spec.Msg) *testHost {
+	if s == nil {
+		s = &spectest.RefSpec{}
+	}
+
+	h := &testHost{addr: ln.Addr().String(), ln: ln, spec: s, handle: handle}
+	t.Cleanup(h.close)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			h.lock.Lock()
+			hc := &hostConn{index: len(h.conns), conn: conn, enc: spec.NewEncoder(s, conn)}
+			h.conns = append(h.conns, hc)
+			h.lock.Unlock()
+			go h.serve(hc)
+		}
+	}()
+
+	return h
+}
+
+func (h *testHost) serve(hc *hostConn) {
+	defer hc.close()
+
+	dec := spec.NewDecoder(h.spec, hc.conn)
+	for {
+		msg, _, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		go func() {
+			if res := h.handle(hc, msg); res != nil {
+				hc.send(res)
+			}
+		}()
+	}
+}
+
+// connCount return the number of accepted connections
+func (h *testHost) connCount() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return len(h.conns)
+}
+
+// conn return the i-th accepted connection, nil if it is not accepted yet
+func (h *testHost) conn(i int) *hostConn {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if i >= len(h.conns) {
+		return nil
+	}
+	return h.conns[i]
+}
+
+func (h *testHost) close() {
+	h.ln.Close()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, hc := range h.conns {
+		hc.close()
+	}
+}
+
+func (hc *hostConn) send(msg spec.Msg) error {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	_, err := hc.enc.Encode(msg)
+	return err
+}
+
+func (hc *hostConn) close() {
+	hc.conn.Close()
+}
+
+// approve respond network management with "00", and other request with "00" and field 44 set to the connection index
+func approve(hc *hostConn, msg spec.Msg) spec.Msg {
+	if msg[0] == "0800" {
+		res, _ := spec.NewNetworkMgmtResponse(msg, "00")
+		return res
+	}
+	res, err := spec.NewResponse(msg, "00", "")
+	if err != nil {
+		return nil
+	}
+	res[44] = strconv.Itoa(hc.index)
+	return res
+}
+
+// serveHost serve RefSpec host that approve every request
+func serveHost(t *testing.T) string {
+	return newTestHost(t, nil, approve).addr
+}
+
+// closedHost return address of host that is not listening anymore, it can be brought back with newTestHostAt
+func closedHost(t *testing.T) string {
+	h := newTestHost(t, nil, approve)
+	h.close()
+	return h.addr
+}
+
+// build the Upstream and close it when the test is done
+func build(t *testing.T, b *upstream.Builder) *upstream.Upstream {
+	u, err := b.Build()
+	if err != nil {
+		t.Fatalf("invalid build: %s", err.Error())
+	}
+	t.Cleanup(func() { u.Close() })
+	return u
+}
+
+// waitFor wait until cond is true, up to 5 seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("invalid state: timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testLogger collect log of Upstream
+type testLogger struct {
+	t *testing.T
+
+	lock sync.Mutex
+	errs []string
+}
+
+func newTestLogger(t *testing.T) *testLogger {
+	return &testLogger{t: t}
+}
+
+func (l *testLogger) info(s string) {
+	l.t.Log("INFO", s)
+}
+
+func (l *testLogger) err(s string) {
+	l.t.Log("ERR", s)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.errs = append(l.errs, s)
+}
+
+// hasErr report whether any error log contains sub
+func (l *testLogger) hasErr(sub string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, s := range l.errs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func request(stan string) spec.Msg {
+	return spec.Msg{0: "0200", 3: "000000", 11: stan, 41: "TERM0001"}
+}
+
+func process(u *upstream.Upstream, msg spec.Msg) (spec.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return u.Process(ctx, msg)
+}
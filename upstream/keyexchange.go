@@ -0,0 +1,139 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+// KeyExchangeFunc exchange working keys with the host.
+// hostReq is nil when it is called after sign on,
+// or the key change request when the exchange is initiated by the host.
+type KeyExchangeFunc func(ctx context.Context, process ProcessFunc, hostReq spec.Msg) (spec.WorkingKeys, error)
+
+func (u *Upstream) exchangeKeys(ctx context.Context, c *connection, hostReq spec.Msg) error {
+	var keys spec.WorkingKeys
+	err := u.runNetworkMgmt(ctx, c, func(ctx context.Context, process ProcessFunc) error {
+		var err error
+		keys, err = u.keyExchange.fn(ctx, process, hostReq)
+		return err
+	}, u.keyExchange.timeout)
+	if err != nil {
+		return err
+	}
+
+	u.setWorkingKeys(c, &keys)
+	u.logInfo("%sworking keys exchanged", c.prefix)
+
+	return nil
+}
+
+// setWorkingKeys set the working keys of c, nil means c is closed.
+// WorkingKeys return the most recently exchanged keys,
+// when the connection that own them is closed, the keys of another connection is used.
+func (u *Upstream) setWorkingKeys(c *connection, keys *spec.WorkingKeys) {
+	u.keyExchange.lock.Lock()
+
+	old := c.keys
+	c.keys = keys
+
+	switch {
+	case keys != nil:
+		u.keyExchange.keys, u.keyExchange.from = keys, c
+	case u.keyExchange.from == c:
+		u.keyExchange.keys, u.keyExchange.from = nil, nil
+		for _, other := range u.pool.list() {
+			if other != c && other.keys != nil {
+				u.keyExchange.keys, u.keyExchange.from = other.keys, other
+			}
+		}
+	}
+
+	u.keyExchange.lock.Unlock()
+
+	// the spec is updated by withKeys before it is used by any connection,
+	// it is also updated here, so it is consistent with WorkingKeys when there is only one connection
+	u.keyExchange.specLock.Lock()
+	defer u.keyExchange.specLock.Unlock()
+
+	if keys != nil || (old != nil && u.keyExchange.specKeys == old) {
+		u.keyExchange.specKeys = keys
+		if k, ok := u.spec.(spec.KeyAware); ok {
+			k.SetWorkingKeys(keys)
+		}
+	}
+}
+
+// withKeys run fn while the spec hold the working keys of c, so it is serialized across connections
+func (u *Upstream) withKeys(c *connection, fn func()) {
+	k, ok := u.spec.(spec.KeyAware)
+	if !ok || u.keyExchange.fn == nil {
+		fn()
+		return
+	}
+
+	u.keyExchange.specLock.Lock()
+	defer u.keyExchange.specLock.Unlock()
+
+	u.keyExchange.lock.RLock()
+	keys := c.keys
+	u.keyExchange.lock.RUnlock()
+
+	if u.keyExchange.specKeys != keys {
+		u.keyExchange.specKeys = keys
+		k.SetWorkingKeys(keys)
+	}
+
+	fn()
+}
+
+// prepare run the BeforeEncode hooks of the spec (see spec.Preparer), msg is returned as is if the spec is not decorated
+func (u *Upstream) prepare(msg spec.Msg) (spec.Msg, error) {
+	if p, ok := u.spec.(spec.Preparer); ok {
+		return p.Prepare(msg)
+	}
+	return msg, nil
+}
+
+// encodePrepared encode message returned by prepare with the working keys of c, see withKeys
+func (u *Upstream) encodePrepared(c *connection, prepared spec.Msg) (encoded []byte, err error) {
+	u.withKeys(c, func() {
+		if p, ok := u.spec.(spec.Preparer); ok {
+			encoded, err = p.EncodePrepared(prepared)
+		} else {
+			encoded, err = u.spec.MsgEncode(prepared)
+		}
+	})
+	return encoded, err
+}
+
+// connSpec is the spec used by the reader of a connection, see withKeys
+type connSpec struct {
+	spec.Spec
+	u *Upstream
+	c *connection
+}
+
+func (u *Upstream) connSpec(c *connection) spec.Spec {
+	return &connSpec{Spec: u.spec, u: u, c: c}
+}
+
+func (s *connSpec) MsgDecode(encoded []byte) (advance int, decoded spec.Msg, needMore int, err error) {
+	s.u.withKeys(s.c, func() {
+		advance, decoded, needMore, err = s.Spec.MsgDecode(encoded)
+	})
+	return advance, decoded, needMore, err
+}
+
+// WorkingKeys return the most recently exchanged working keys of the connected connections,
+// it returns error if there is no connection or the key exchange is not done yet.
+func (u *Upstream) WorkingKeys() (spec.WorkingKeys, error) {
+	u.keyExchange.lock.RLock()
+	defer u.keyExchange.lock.RUnlock()
+
+	if u.keyExchange.keys == nil {
+		return spec.WorkingKeys{}, fmt.Errorf("working keys not available")
+	}
+	return *u.keyExchange.keys, nil
+}
@@ -0,0 +1,212 @@
+package upstream_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+// keySpec is RefSpec that send the MAC working key in field 48 of 0200 (simulating MAC),
+// and doesn't auto respond, so host initiated key change is responded by Upstream
+func keySpec() spec.Spec {
+	holder := &spec.KeyHolder{}
+	return spec.Decorate(&spectest.RefSpec{}, holder.Hooks(), spec.Hooks{
+		AfterEncode: func(msg spec.Msg, encoded []byte) ([]byte, error) {
+			if msg[0] != "0200" {
+				return encoded, nil
+			}
+			keys := holder.WorkingKeys()
+			if keys == nil {
+				return nil, fmt.Errorf("no working keys")
+			}
+			msg = msg.Clone()
+			msg[48] = string(keys.MAC)
+			return (&spectest.RefSpec{}).MsgEncode(msg)
+		},
+		AutoResp: func(req spec.Msg, resp spec.Msg) spec.Msg {
+			return nil
+		},
+	})
+}
+
+// keyChange request the working key from the host, or take it from field 48 of host initiated key change
+func keyChange(ctx context.Context, process upstream.ProcessFunc, hostReq spec.Msg) (spec.WorkingKeys, error) {
+	if hostReq != nil {
+		if hostReq[48] == "" {
+			return spec.WorkingKeys{}, fmt.Errorf("missing key")
+		}
+		return spec.WorkingKeys{MAC: []byte(hostReq[48])}, nil
+	}
+	res, err := process(ctx, spec.NewNetworkMgmtMsg(spec.NMIKeyChange))
+	if err != nil {
+		return spec.WorkingKeys{}, err
+	}
+	if res[39] != "00" {
+		return spec.WorkingKeys{}, fmt.Errorf("key change declined with response code %q", res[39])
+	}
+	return spec.WorkingKeys{MAC: []byte(res[48])}, nil
+}
+
+func workingMACKey(u *upstream.Upstream) string {
+	keys, err := u.WorkingKeys()
+	if err != nil {
+		return ""
+	}
+	return string(keys.MAC)
+}
+
+func TestHostKeyChange(t *testing.T) {
+	responses := make(chan spec.Msg, 2)
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		switch {
+		case msg[0] == "0810":
+			responses <- msg
+			return nil
+		case spec.IsNetworkMgmt(msg, spec.NMIKeyChange):
+			res := approve(hc, msg)
+			res[48] = "KEY1"
+			return res
+		case msg[0] == "0200" && msg[48] != "KEY2":
+			res := approve(hc, msg)
+			res[39] = "96"
+			return res
+		}
+		return approve(hc, msg)
+	})
+
+	l := newTestLogger(t)
+	u := build(t, upstream.NewBuilder().
+		WithTarget(h.addr).
+		WithSpec(keySpec()).
+		WithLogger(l.info, l.err).
+		WithKeyExchange(keyChange, time.Second))
+	waitFor(t, "connected", func() bool { return len(u.ConnectedTargets()) == 1 })
+	if key := workingMACKey(u); key != "KEY1" {
+		t.Fatalf("invalid working key after sign on: %q", key)
+	}
+
+	for _, tc := range []struct {
+		key      string
+		respCode string
+	}{
+		{"KEY2", "00"},
+		// failed exchange is responded with "96", the current keys are kept
+		{"", "96"},
+	} {
+		req := hostRequest(spec.NMIKeyChange, "000900")
+		if tc.key != "" {
+			req[48] = tc.key
+		}
+		if err := h.conn(0).send(req); err != nil {
+			t.Fatalf("invalid send: %s", err.Error())
+		}
+
+		select {
+		case res := <-responses:
+			if res[11] != "000900" || res[39] != tc.respCode {
+				t.Fatalf("invalid key change response: %v", res)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("invalid state: key change is not responded")
+		}
+		if key := workingMACKey(u); key != "KEY2" {
+			t.Fatalf("invalid working key: %q", key)
+		}
+	}
+
+	if !l.hasErr("host initiated key exchange failed: missing key") {
+		t.Fatalf("invalid state: failed key exchange is not logged")
+	}
+
+	res, err := process(u, request("000001"))
+	if err != nil || res[39] != "00" || h.connCount() != 1 {
+		t.Fatalf("invalid process after key change: %v %v, %d connections", res, err, h.connCount())
+	}
+}
+
+func TestKeyExchangeFailed(t *testing.T) {
+	// the first connection doesn't respond to key change, the second decline it, the third approve it
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		res := approve(hc, msg)
+		if spec.IsNetworkMgmt(msg, spec.NMIKeyChange) {
+			switch hc.index {
+			case 0:
+				return nil
+			case 1:
+				res[39] = "91"
+			default:
+				res[48] = fmt.Sprintf("KEY%d", hc.index)
+			}
+		}
+		return res
+	})
+
+	l := newTestLogger(t)
+	u := build(t, upstream.NewBuilder().
+		WithTarget(h.addr).
+		WithSpec(keySpec()).
+		WithLogger(l.info, l.err).
+		WithBackoff(upstream.ConstantBackoff(10*time.Millisecond), time.Second).
+		WithKeyExchange(keyChange, 100*time.Millisecond))
+	waitFor(t, "connected", func() bool { return len(u.ConnectedTargets()) == 1 })
+
+	res, err := process(u, request("000001"))
+	if err != nil {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+	if res[44] != "2" || workingMACKey(u) != "KEY2" {
+		t.Fatalf("request must only be sent through connection with working keys: %v", res)
+	}
+	for _, expected := range []string{"key exchange failed: timeout", `key exchange failed: key change declined with response code "91"`} {
+		if !l.hasErr(expected) {
+			t.Fatalf("invalid state: %q is not logged", expected)
+		}
+	}
+}
+
+func TestProcessBeforeKeyExchange(t *testing.T) {
+	// request submitted before the working keys are installed wait for them
+	var keySent int32
+	var lock sync.Mutex
+	var received []spec.Msg
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		res := approve(hc, msg)
+		switch {
+		case spec.IsNetworkMgmt(msg, spec.NMIKeyChange):
+			time.Sleep(200 * time.Millisecond)
+			res[48] = "KEY0"
+			atomic.StoreInt32(&keySent, 1)
+		case msg[0] == "0200":
+			if atomic.LoadInt32(&keySent) == 0 {
+				res[39] = "96"
+			}
+			lock.Lock()
+			received = append(received, msg)
+			lock.Unlock()
+		}
+		return res
+	})
+
+	u := build(t, upstream.NewBuilder().
+		WithTarget(h.addr).
+		WithSpec(keySpec()).
+		WithKeyExchange(keyChange, time.Second))
+
+	res, err := process(u, request("000001"))
+	if err != nil {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if res[39] != "00" || len(received) != 1 || received[0][48] != "KEY0" {
+		t.Fatalf("request must be sent after key exchange with the working keys: %v", received)
+	}
+}
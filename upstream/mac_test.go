@@ -0,0 +1,76 @@
+package upstream_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/crypto/mac"
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func newMACSpec() spec.Spec {
+	key, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+	return mac.Wrap(&spectest.RefSpec{}, mac.Config{
+		Compute:  mac.WithKey(mac.ISO9797Alg3, mac.StaticKey(key)),
+		Encoding: mac.EncodingHex,
+		Skip:     4,
+	})
+}
+
+func TestProcessInvalidMAC(t *testing.T) {
+	// the host send response with invalid MAC when field 48 is "BAD", and without MAC when it is "NONE"
+	hostSpec := spec.Decorate(newMACSpec(), spec.Hooks{
+		AfterEncode: func(msg spec.Msg, encoded []byte) ([]byte, error) {
+			switch msg[48] {
+			case "BAD":
+				encoded = append([]byte(nil), encoded...)
+				if encoded[len(encoded)-1] == '0' {
+					encoded[len(encoded)-1] = '1'
+				} else {
+					encoded[len(encoded)-1] = '0'
+				}
+			case "NONE":
+				msg = msg.Clone()
+				delete(msg, 64)
+				return (&spectest.RefSpec{}).MsgEncode(msg)
+			}
+			return encoded, nil
+		},
+	})
+	h := newTestHost(t, hostSpec, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		res := approve(hc, msg)
+		if v, ok := msg[48]; ok {
+			res[48] = v
+		}
+		return res
+	})
+
+	u := build(t, upstream.NewBuilder().WithTarget(h.addr).WithSpec(newMACSpec()))
+
+	for _, tc := range []struct {
+		mode     string
+		expected error
+	}{
+		{"OK", nil},
+		{"BAD", mac.ErrInvalidMAC},
+		{"NONE", mac.ErrMissingMAC},
+		{"OK", nil},
+	} {
+		req := request("000001")
+		req[48] = tc.mode
+		res, err := process(u, req)
+		if !errors.Is(err, tc.expected) {
+			t.Fatalf("%s: invalid err: %v", tc.mode, err)
+		}
+		if err == nil && res[39] != "00" {
+			t.Fatalf("%s: invalid response: %v", tc.mode, res)
+		}
+	}
+
+	if h.connCount() != 1 {
+		t.Fatalf("invalid connection count: %d, connection must not be dropped", h.connCount())
+	}
+}
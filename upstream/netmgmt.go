@@ -0,0 +1,129 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+const defaultNetworkMgmtTimeout = 30 * time.Second
+
+// ProcessFunc send msg to the host and wait for the response.
+type ProcessFunc func(ctx context.Context, msg spec.Msg) (spec.Msg, error)
+
+// NetworkMgmtFunc run network management procedure (sign on, sign off, echo, etc).
+// process send message through the current connection, bypassing the queue of normal requests,
+// it fill field 11 when the message doesn't have it, see Builder.WithSTAN.
+type NetworkMgmtFunc func(ctx context.Context, process ProcessFunc) error
+
+// SimpleNetworkMgmt return NetworkMgmtFunc that send spec.NewNetworkMgmtMsg(code)
+// and expect response code (field 39) "00".
+func SimpleNetworkMgmt(code string) NetworkMgmtFunc {
+	return func(ctx context.Context, process ProcessFunc) error {
+		resp, err := process(ctx, spec.NewNetworkMgmtMsg(code))
+		if err != nil {
+			return err
+		}
+		if resp[39] != "00" {
+			return fmt.Errorf("network management %s declined with response code %q", code, resp[39])
+		}
+		return nil
+	}
+}
+
+// priorityProcess return ProcessFunc that send message through c, bypassing the queue of normal requests
+func (u *Upstream) priorityProcess(c *connection) ProcessFunc {
+	return func(ctx context.Context, msg spec.Msg) (spec.Msg, error) {
+		return u.process(ctx, msg, c)
+	}
+}
+
+func (u *Upstream) runNetworkMgmt(ctx context.Context, c *connection, fn NetworkMgmtFunc, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultNetworkMgmtTimeout
+	}
+	ctx, cancelCtx := context.WithTimeout(ctx, timeout)
+	defer cancelCtx()
+
+	err := fn(ctx, u.priorityProcess(c))
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("timeout")
+	}
+	return err
+}
+
+// signOff sign off every signed on connection concurrently
+func (u *Upstream) signOff() {
+	if u.netMgmt.signOff == nil {
+		return
+	}
+
+	var wait sync.WaitGroup
+	for _, c := range u.pool.list() {
+		if !c.isSignedOn() {
+			continue
+		}
+
+		c := c
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+
+			if err := u.runNetworkMgmt(c.ctx, c, u.netMgmt.signOff, u.netMgmt.signOffTimeout); err != nil {
+				u.logErr("%ssign off failed: %s", c.prefix, err.Error())
+				return
+			}
+
+			u.logInfo("%ssigned off", c.prefix)
+		}()
+	}
+	wait.Wait()
+}
+
+func (u *Upstream) echo(ctx context.Context, c *connection) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(u.netMgmt.echoInterval):
+		}
+
+		if err := u.runNetworkMgmt(ctx, c, u.netMgmt.echo, u.netMgmt.echoInterval); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("echo failed: %s", err.Error())
+		}
+	}
+}
+
+// handleNetworkMgmt handle host initiated network management request (cutover and key change),
+// it returns the response if the spec doesn't auto respond to it
+func (u *Upstream) handleNetworkMgmt(c *connection, msg spec.Msg) spec.Msg {
+	respCode := ""
+
+	switch {
+	case u.netMgmt.onCutover != nil && spec.IsNetworkMgmt(msg, spec.NMICutover):
+		u.netMgmt.onCutover(msg)
+		respCode = "00"
+
+	case u.keyExchange.fn != nil && spec.IsNetworkMgmt(msg, spec.NMIKeyChange):
+		respCode = "00"
+		if err := u.exchangeKeys(c.ctx, c, msg); err != nil {
+			u.logErr("%shost initiated key exchange failed: %s", c.prefix, err.Error())
+			respCode = "96"
+		}
+
+	default:
+		return nil
+	}
+
+	resp, err := spec.NewNetworkMgmtResponse(msg, respCode)
+	if err != nil {
+		return nil
+	}
+	return resp
+}
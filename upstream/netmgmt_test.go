@@ -0,0 +1,202 @@
+package upstream_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/seq"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+// hostRequest is network management request sent by the host
+func hostRequest(code, stan string) spec.Msg {
+	req := spec.NewNetworkMgmtMsg(code)
+	req[11] = stan
+	return req
+}
+
+func TestCutover(t *testing.T) {
+	responses := make(chan spec.Msg, 1)
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		if msg[0] == "0810" {
+			responses <- msg
+			return nil
+		}
+		return approve(hc, msg)
+	})
+
+	cutover := make(chan spec.Msg, 1)
+	u := build(t, upstream.NewBuilder().
+		WithTarget(h.addr).
+		WithSpec(&spectest.RefSpec{}).
+		WithCutoverHandler(func(msg spec.Msg) { cutover <- msg }))
+	waitFor(t, "connected", func() bool { return len(u.ConnectedTargets()) == 1 })
+
+	if err := h.conn(0).send(hostRequest(spec.NMICutover, "000777")); err != nil {
+		t.Fatalf("invalid send: %s", err.Error())
+	}
+
+	select {
+	case msg := <-cutover:
+		if msg[11] != "000777" {
+			t.Fatalf("invalid cutover notification: %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("invalid state: cutover handler is not called")
+	}
+
+	select {
+	case res := <-responses:
+		if res[11] != "000777" || res[39] != "00" || res[70] != spec.NMICutover {
+			t.Fatalf("invalid cutover response: %v", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("invalid state: cutover is not responded")
+	}
+
+	// the connection keep serving
+	if _, err := process(u, request("000001")); err != nil || h.connCount() != 1 {
+		t.Fatalf("invalid process after cutover: %v, %d connections", err, h.connCount())
+	}
+}
+
+func TestSignOffOnClose(t *testing.T) {
+	var signOff int32
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		if spec.IsNetworkMgmt(msg, spec.NMISignOff) {
+			atomic.AddInt32(&signOff, 1)
+		}
+		return approve(hc, msg)
+	})
+
+	l := newTestLogger(t)
+	u := build(t, upstream.NewBuilder().
+		WithTarget(h.addr).
+		WithSpec(&spectest.RefSpec{}).
+		WithLogger(l.info, l.err).
+		WithPool(2, upstream.BalanceRoundRobin).
+		WithSignOn(upstream.SimpleNetworkMgmt(spec.NMISignOn), time.Second).
+		WithSignOff(upstream.SimpleNetworkMgmt(spec.NMISignOff), time.Second))
+	waitFor(t, "pool connected", func() bool { return len(u.ConnectedTargets()) == 2 })
+
+	if n := atomic.LoadInt32(&signOff); n != 0 {
+		t.Fatalf("invalid sign off count before Close: %d", n)
+	}
+
+	u.Close()
+
+	// every connection is signed off before Close returns
+	if n := atomic.LoadInt32(&signOff); n != 2 || l.hasErr("sign off failed") {
+		t.Fatalf("invalid sign off count: %d, error logged: %v", n, l.hasErr("sign off failed"))
+	}
+}
+
+func TestSignOnRetry(t *testing.T) {
+	// the first sign on is declined, the connection is dropped and reconnected
+	var lock sync.Mutex
+	signOn := make(map[int]int)
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		res := approve(hc, msg)
+		if spec.IsNetworkMgmt(msg, spec.NMISignOn) {
+			lock.Lock()
+			defer lock.Unlock()
+			signOn[hc.index]++
+			if hc.index == 0 {
+				res[39] = "91"
+			}
+		}
+		return res
+	})
+
+	l := newTestLogger(t)
+	u := build(t, upstream.NewBuilder().
+		WithTarget(h.addr).
+		WithSpec(&spectest.RefSpec{}).
+		WithLogger(l.info, l.err).
+		WithBackoff(upstream.ConstantBackoff(10*time.Millisecond), time.Second).
+		WithSignOn(upstream.SimpleNetworkMgmt(spec.NMISignOn), time.Second))
+	waitFor(t, "connected", func() bool { return len(u.ConnectedTargets()) == 1 })
+
+	res, err := process(u, request("000001"))
+	if err != nil {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+	if res[44] == "0" {
+		t.Fatalf("request must not be sent through connection that failed to sign on")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if signOn[0] != 1 || h.connCount() < 2 || !l.hasErr(`sign on failed: network management 001 declined with response code "91"`) {
+		t.Fatalf("invalid sign on: %v, %d connections", signOn, h.connCount())
+	}
+}
+
+func TestNetworkMgmtSTAN(t *testing.T) {
+	var lock sync.Mutex
+	var signOn []string
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		if spec.IsNetworkMgmt(msg, spec.NMISignOn) {
+			lock.Lock()
+			signOn = append(signOn, msg[11])
+			lock.Unlock()
+		}
+		return approve(hc, msg)
+	})
+
+	u := build(t, upstream.NewBuilder().
+		WithTarget(h.addr).
+		WithSpec(&spectest.RefSpec{}).
+		WithSTAN(seq.NewSTAN(nil)).
+		WithPool(2, upstream.BalanceRoundRobin).
+		WithSignOn(upstream.SimpleNetworkMgmt(spec.NMISignOn), time.Second))
+	waitFor(t, "pool connected", func() bool { return len(u.ConnectedTargets()) == 2 })
+
+	// network management messages and requests without terminal share the sequence of WithSTAN
+	res, err := process(u, spec.Msg{0: "0200", 3: "000000"})
+	if err != nil {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(signOn) != 2 || signOn[0] == signOn[1] || res[11] != "000003" {
+		t.Fatalf("invalid STAN: sign on %v, request %s", signOn, res[11])
+	}
+}
+
+func TestNetworkMgmtPool(t *testing.T) {
+	var signOn, echo int32
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		switch {
+		case spec.IsNetworkMgmt(msg, spec.NMISignOn):
+			atomic.AddInt32(&signOn, 1)
+		case spec.IsNetworkMgmt(msg, spec.NMIEcho):
+			atomic.AddInt32(&echo, 1)
+		}
+		return approve(hc, msg)
+	})
+
+	l := newTestLogger(t)
+	u := build(t, upstream.NewBuilder().
+		WithTarget(h.addr).
+		WithSpec(&spectest.RefSpec{}).
+		WithLogger(l.info, l.err).
+		WithPool(4, upstream.BalanceRoundRobin).
+		WithSignOn(upstream.SimpleNetworkMgmt(spec.NMISignOn), time.Second).
+		WithEcho(upstream.SimpleNetworkMgmt(spec.NMIEcho), 50*time.Millisecond))
+
+	waitFor(t, "pool connected", func() bool { return len(u.ConnectedTargets()) == 4 })
+	waitFor(t, "echo", func() bool { return atomic.LoadInt32(&echo) >= 12 })
+
+	if n := atomic.LoadInt32(&signOn); n != 4 {
+		t.Fatalf("invalid sign on count: %d", n)
+	}
+	if h.connCount() != 4 || l.hasErr("") {
+		t.Fatalf("invalid state: %d connections, error logged: %v", h.connCount(), l.hasErr(""))
+	}
+}
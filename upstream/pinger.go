@@ -7,53 +7,35 @@ import (
 	"time"
 )
 
-const minPingDuration = 5 * time.Second
-
-func (u *Upstream) pinger(ctx context.Context) error {
+func (u *Upstream) pinger(ctx context.Context, c *connection) error {
 	for {
 		msg, duration := u.spec.GetPingMsg()
 		if duration == 0 {
 			return nil
 		}
 
-		if duration < minPingDuration {
-			duration = minPingDuration
-		}
-
-		msgRaw, err := u.spec.MsgEncode(msg)
-		if err != nil {
-			panic("spec error: MsgEncode ping msg:" + err.Error())
+		if duration < u.timeouts.minPing {
+			duration = u.timeouts.minPing
 		}
 
-		s := newSubmission(u.spec.MsgID(msg), msg, msgRaw, true)
-
-		u.submission.data.lock.Lock()
-		u.submission.data.data[s.id] = s
-		u.submission.data.lock.Unlock()
-
-		removeSubmission := func(err error) {
-			s.setErr(err)
-			u.submission.data.lock.Lock()
-			delete(u.submission.data.data, s.id)
-			u.submission.data.lock.Unlock()
-		}
+		s := newSubmission("", msg, true)
 
 		select {
 		case <-ctx.Done():
-			removeSubmission(ctx.Err())
+			u.removeSubmission(s, ctx.Err())
 			return ctx.Err()
-		case u.submission.priorityNotify <- s:
+		case c.priorityNotify <- s:
 		}
 
 		select {
 		case <-ctx.Done():
-			removeSubmission(ctx.Err())
+			u.removeSubmission(s, ctx.Err())
 			return ctx.Err()
 		case <-time.After(duration):
-			removeSubmission(context.DeadlineExceeded)
+			u.removeSubmission(s, context.DeadlineExceeded)
 		}
 
-		if (time.Now().Unix() - atomic.LoadInt64(&u.lastActive)) > int64(duration.Seconds()) {
+		if time.Duration(time.Now().UnixNano()-atomic.LoadInt64(&c.lastActive)) > duration {
 			return fmt.Errorf("inactive for %s", duration.String())
 		}
 	}
@@ -0,0 +1,257 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+// Balance is the policy to distribute requests across connections, see Builder.WithPool.
+type Balance int
+
+// Balance values
+const (
+	// BalanceRoundRobin send requests to every connection in turn
+	BalanceRoundRobin Balance = iota
+
+	// BalanceLeastInFlight send request to the connection with the fewest requests waiting for response
+	BalanceLeastInFlight
+)
+
+var errConnClosed = fmt.Errorf("connection closed")
+
+// connection is the state of one connection in the pool
+type connection struct {
+	ctx    context.Context
+	prefix string
+	target *target
+
+	// priorityNotify is used for network management, auto response and ping of this connection,
+	// notify is used for normal requests dispatched to this connection
+	priorityNotify chan *submission
+	notify         chan *submission
+
+	lastActive int64
+	signedOn   int32
+
+	keys *spec.WorkingKeys
+
+	inFlight struct {
+		lock sync.Mutex
+		data map[*submission]struct{}
+	}
+}
+
+func newConnection(ctx context.Context, prefix string, t *target) *connection {
+	c := &connection{ctx: ctx, prefix: prefix, target: t}
+	c.priorityNotify = make(chan *submission)
+	c.notify = make(chan *submission)
+	c.inFlight.data = make(map[*submission]struct{})
+	return c
+}
+
+func (c *connection) track(s *submission) {
+	c.inFlight.lock.Lock()
+	c.inFlight.data[s] = struct{}{}
+	c.inFlight.lock.Unlock()
+}
+
+// untrack return false if s is not tracked by c
+func (c *connection) untrack(s *submission) bool {
+	c.inFlight.lock.Lock()
+	defer c.inFlight.lock.Unlock()
+
+	_, ok := c.inFlight.data[s]
+	delete(c.inFlight.data, s)
+	return ok
+}
+
+func (c *connection) inFlightCount() int {
+	c.inFlight.lock.Lock()
+	defer c.inFlight.lock.Unlock()
+
+	return len(c.inFlight.data)
+}
+
+// takeInFlight return and clear the submissions dispatched to this connection that still waiting for response
+func (c *connection) takeInFlight() []*submission {
+	c.inFlight.lock.Lock()
+	defer c.inFlight.lock.Unlock()
+
+	var ret []*submission
+	for s := range c.inFlight.data {
+		if !s.isDone() {
+			ret = append(ret, s)
+		}
+	}
+	c.inFlight.data = make(map[*submission]struct{})
+	return ret
+}
+
+func (c *connection) isSignedOn() bool {
+	return atomic.LoadInt32(&c.signedOn) != 0
+}
+
+// pool is the set of ready (connected and signed on) connections
+type pool struct {
+	size    int
+	balance Balance
+
+	lock    sync.Mutex
+	conns   []*connection
+	next    int
+	changed chan struct{}
+}
+
+func (p *pool) init() {
+	if p.size <= 0 {
+		p.size = 1
+	}
+	p.changed = make(chan struct{})
+}
+
+func (p *pool) notifyChanged() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// wake wake up dispatch that waiting for ready connection, e.g. to recheck the circuit breaker
+func (p *pool) wake() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.notifyChanged()
+}
+
+func (p *pool) add(c *connection) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.conns = append(p.conns, c)
+	p.notifyChanged()
+}
+
+func (p *pool) remove(c *connection) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i, v := range p.conns {
+		if v == c {
+			p.conns = append(p.conns[:i:i], p.conns[i+1:]...)
+			p.notifyChanged()
+			return
+		}
+	}
+}
+
+func (p *pool) list() []*connection {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return append([]*connection(nil), p.conns...)
+}
+
+// pick return connection for the next request,
+// or nil and channel that is closed when the set of ready connections changed
+func (p *pool) pick() (*connection, <-chan struct{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.conns) == 0 {
+		return nil, p.changed
+	}
+
+	start := p.next % len(p.conns)
+	p.next = start + 1
+
+	picked := p.conns[start]
+	if p.balance == BalanceLeastInFlight {
+		min := picked.inFlightCount()
+		for i := 1; i < len(p.conns) && min > 0; i++ {
+			c := p.conns[(start+i)%len(p.conns)]
+			if n := c.inFlightCount(); n < min {
+				picked, min = c, n
+			}
+		}
+	}
+
+	return picked, nil
+}
+
+func (p *pool) untrack(s *submission) {
+	for _, c := range p.list() {
+		c.untrack(s)
+	}
+}
+
+// dispatch hand normal request to the writer of a ready connection, the connection track it since it is picked
+func (u *Upstream) dispatch(ctx context.Context, s *submission) error {
+	for {
+		c, changed := u.pool.pick()
+		if c == nil {
+			if err := u.breaker.check(); err != nil {
+				return err
+			}
+
+			select {
+			case <-u.lifetimeCtx.Done():
+				return ErrServerClosed
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.doneCh:
+				return nil
+			case <-changed:
+			}
+			continue
+		}
+
+		c.track(s)
+
+		select {
+		case <-u.lifetimeCtx.Done():
+			c.untrack(s)
+			return ErrServerClosed
+		case <-ctx.Done():
+			c.untrack(s)
+			return ctx.Err()
+		case <-s.doneCh:
+			c.untrack(s)
+			return nil
+		case <-c.ctx.Done():
+			if !c.untrack(s) {
+				// already taken by redispatch
+				return nil
+			}
+		case c.notify <- s:
+			return nil
+		}
+	}
+}
+
+// redispatch send requests that was dispatched to closed connection c to another connection
+func (u *Upstream) redispatch(c *connection) {
+	for _, s := range c.takeInFlight() {
+		s := s
+		u.wait.Add(1)
+		go func() {
+			defer u.wait.Done()
+			if err := u.dispatch(u.lifetimeCtx, s); err != nil {
+				u.removeSubmission(s, err)
+			}
+		}()
+	}
+}
+
+// removeSubmission finish s with err and remove it from submission data
+func (u *Upstream) removeSubmission(s *submission, err error) {
+	s.setErr(err)
+	u.submission.data.lock.Lock()
+	if s.id != "" && u.submission.data.data[s.id] == s {
+		delete(u.submission.data.data, s.id)
+	}
+	u.submission.data.lock.Unlock()
+	u.pool.untrack(s)
+}
@@ -0,0 +1,271 @@
+package upstream_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func buildPool(t *testing.T, addr string, size int, balance upstream.Balance) *upstream.Upstream {
+	u := build(t, upstream.NewBuilder().
+		WithTarget(addr).
+		WithSpec(&spectest.RefSpec{}).
+		WithPool(size, balance))
+	waitFor(t, "pool connected", func() bool { return len(u.ConnectedTargets()) == size })
+	return u
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	h := newTestHost(t, nil, approve)
+	u := buildPool(t, h.addr, 3, upstream.BalanceRoundRobin)
+
+	count := make(map[string]int)
+	for i := 1; i <= 30; i++ {
+		res, err := process(u, request(fmt.Sprintf("%06d", i)))
+		if err != nil {
+			t.Fatalf("invalid process: %s", err.Error())
+		}
+		count[res[44]]++
+	}
+
+	if len(count) != 3 {
+		t.Fatalf("invalid distribution: %v", count)
+	}
+	for conn, n := range count {
+		if n != 10 {
+			t.Fatalf("invalid distribution: connection %s got %d requests", conn, n)
+		}
+	}
+}
+
+func TestPoolLeastInFlight(t *testing.T) {
+	// only the first connection respond, the others hold the requests
+	var received int32
+	var lock sync.Mutex
+	count := make(map[int]int)
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		if msg[0] == "0200" {
+			lock.Lock()
+			count[hc.index]++
+			lock.Unlock()
+			atomic.AddInt32(&received, 1)
+			if hc.index != 0 {
+				return nil
+			}
+		}
+		return approve(hc, msg)
+	})
+	u := buildPool(t, h.addr, 3, upstream.BalanceLeastInFlight)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var completed int32
+	for i := 1; i <= 30; i++ {
+		go func(stan string) {
+			if _, err := u.Process(ctx, request(stan)); err == nil {
+				atomic.AddInt32(&completed, 1)
+			}
+		}(fmt.Sprintf("%06d", i))
+
+		// wait until the response of the first connection is processed, so the in-flight counts are stable
+		waitFor(t, "request received", func() bool {
+			lock.Lock()
+			held := 0
+			for conn, n := range count {
+				if conn != 0 {
+					held += n
+				}
+			}
+			lock.Unlock()
+			return atomic.LoadInt32(&received) == int32(i) && held+int(atomic.LoadInt32(&completed)) == i
+		})
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	for conn, n := range count {
+		if conn != 0 && n > 1 {
+			t.Fatalf("invalid distribution: busy connection %d got %d requests", conn, n)
+		}
+	}
+	if count[0] < 28 {
+		t.Fatalf("invalid distribution: idle connection got %d requests", count[0])
+	}
+}
+
+func TestPoolResponseMatching(t *testing.T) {
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		res := approve(hc, msg)
+		if msg[0] == "0200" {
+			time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+			res[48] = msg[48]
+		}
+		return res
+	})
+	u := buildPool(t, h.addr, 4, upstream.BalanceRoundRobin)
+
+	var wait sync.WaitGroup
+	errCh := make(chan error, 200)
+	conns := make([]int32, 4)
+	for i := 1; i <= 200; i++ {
+		i := i
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+
+			req := request(fmt.Sprintf("%06d", i))
+			req[48] = fmt.Sprintf("REQ%d", i)
+			res, err := process(u, req)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if res[11] != req[11] || res[48] != req[48] {
+				errCh <- fmt.Errorf("request %s matched with response %v", req[11], res)
+				return
+			}
+			var conn int
+			fmt.Sscan(res[44], &conn)
+			atomic.AddInt32(&conns[conn%4], 1)
+		}()
+	}
+	wait.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+	for i, n := range conns {
+		if n == 0 {
+			t.Fatalf("invalid distribution: connection %d got no request", i)
+		}
+	}
+}
+
+func TestPoolRedispatch(t *testing.T) {
+	// the first connection is closed by the host without responding
+	var dropped int32
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		if msg[0] == "0200" && hc.index == 0 {
+			atomic.AddInt32(&dropped, 1)
+			hc.close()
+			return nil
+		}
+		return approve(hc, msg)
+	})
+	u := buildPool(t, h.addr, 2, upstream.BalanceRoundRobin)
+
+	for i := 1; i <= 10; i++ {
+		res, err := process(u, request(fmt.Sprintf("%06d", i)))
+		if err != nil {
+			t.Fatalf("invalid process: %s", err.Error())
+		}
+		if res[11] != fmt.Sprintf("%06d", i) || res[44] == "0" {
+			t.Fatalf("invalid response: %v", res)
+		}
+	}
+
+	if atomic.LoadInt32(&dropped) == 0 {
+		t.Fatalf("invalid test: no request sent to the dropped connection")
+	}
+}
+
+func TestPoolWorkingKeys(t *testing.T) {
+	// every connection get different working keys, the MAC field (simulated by field 48) must use the keys of its connection
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		res := approve(hc, msg)
+		switch {
+		case spec.IsNetworkMgmt(msg, spec.NMIKeyChange):
+			res[48] = fmt.Sprintf("KEY%d", hc.index)
+		case msg[0] == "0200" && msg[48] != fmt.Sprintf("KEY%d", hc.index):
+			res[39] = "96"
+		}
+		return res
+	})
+
+	holder := &spec.KeyHolder{}
+	s := spec.Decorate(&spectest.RefSpec{}, holder.Hooks(), spec.Hooks{
+		AfterEncode: func(msg spec.Msg, encoded []byte) ([]byte, error) {
+			if msg[0] != "0200" {
+				return encoded, nil
+			}
+			keys := holder.WorkingKeys()
+			if keys == nil {
+				return nil, fmt.Errorf("no working keys")
+			}
+			msg = msg.Clone()
+			msg[48] = string(keys.MAC)
+			return (&spectest.RefSpec{}).MsgEncode(msg)
+		},
+	})
+
+	var stan int32
+	u := build(t, upstream.NewBuilder().
+		WithTarget(h.addr).
+		WithSpec(s).
+		WithPool(3, upstream.BalanceRoundRobin).
+		WithKeyExchange(func(ctx context.Context, process upstream.ProcessFunc, hostReq spec.Msg) (spec.WorkingKeys, error) {
+			req := spec.NewNetworkMgmtMsg(spec.NMIKeyChange)
+			req[11] = fmt.Sprintf("%06d", 900000+atomic.AddInt32(&stan, 1))
+			res, err := process(ctx, req)
+			if err != nil {
+				return spec.WorkingKeys{}, err
+			}
+			return spec.WorkingKeys{MAC: []byte(res[48])}, nil
+		}, time.Second))
+	waitFor(t, "pool connected", func() bool { return len(u.ConnectedTargets()) == 3 })
+
+	var wait sync.WaitGroup
+	errCh := make(chan error, 60)
+	for i := 1; i <= 60; i++ {
+		i := i
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+
+			res, err := process(u, request(fmt.Sprintf("%06d", i)))
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if res[39] != "00" {
+				errCh <- fmt.Errorf("request %d sent with working keys of another connection", i)
+			}
+		}()
+	}
+	wait.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+}
+
+func TestPoolInvalidConfig(t *testing.T) {
+	for _, tc := range []struct {
+		size     int
+		balance  upstream.Balance
+		expected string
+	}{
+		{-1, upstream.BalanceRoundRobin, "invalid pool size"},
+		{2, upstream.Balance(99), "invalid pool balance"},
+	} {
+		_, err := upstream.NewBuilder().
+			WithTarget("127.0.0.1:1").
+			WithSpec(&spectest.RefSpec{}).
+			WithPool(tc.size, tc.balance).
+			Build()
+		if err == nil || err.Error() != tc.expected {
+			t.Fatalf("invalid err: %v", err)
+		}
+	}
+}
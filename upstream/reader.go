@@ -9,34 +9,73 @@ import (
 	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
 )
 
-func (u *Upstream) reader(ctx context.Context, conn net.Conn, unprocessedRead []byte) error {
-	var msg spec.Msg
-	var msgRaw []byte
-	var err error
-
-	buffer := unprocessedRead
-	bufferLen := len(unprocessedRead)
+func (u *Upstream) reader(ctx context.Context, c *connection, conn net.Conn, unprocessedRead []byte) error {
+	decoder := spec.NewDecoder(u.connSpec(c), conn).WithLimits(u.readLimits).WithBuffered(unprocessedRead)
 
 	for {
-		msg, msgRaw, buffer, bufferLen, err = spec.ReadOneMessage(u.spec, conn, buffer, bufferLen)
+		msg, msgRaw, err := decoder.Decode()
+		if rejected, ok := err.(*spec.ErrRejected); ok {
+			atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+
+			go func() {
+				u.logErr("%sR: %s: %s", c.prefix, rejected.Msg.String(), rejected.Error())
+				u.processRejectedMsg(rejected)
+			}()
+			continue
+		}
 		if err != nil {
-			return err
+			skip := u.resync(c, decoder.Buffered(), err)
+			if skip == 0 {
+				return err
+			}
+			decoder.Discard(skip)
+			continue
 		}
 
-		atomic.StoreInt64(&u.lastActive, time.Now().Unix())
+		atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
 
-		msg := msg
-		msgRaw := msgRaw
 		go func() {
-			u.logInfo("R: %s", msg.String())
-			u.processRecvMsg(msg, msgRaw)
+			u.logInfo("%sR: %s", c.prefix, msg.String())
+			u.processRecvMsg(c, msg, msgRaw)
 		}()
 	}
 }
 
-func (u *Upstream) processRecvMsg(msg spec.Msg, msgRaw []byte) {
+// resync return number of bytes to discard to recover from framing error, 0 means the connection must be dropped
+func (u *Upstream) resync(c *connection, buffered []byte, err error) int {
+	switch err.(type) {
+	case *spec.ErrDecode, *spec.ErrMessageTooLarge:
+	default:
+		return 0
+	}
+
+	r, ok := u.spec.(spec.Resyncer)
+	if !ok {
+		return 0
+	}
+
+	skip := r.Resync(buffered)
+	if skip <= 0 {
+		return 0
+	}
+	if skip > len(buffered) {
+		skip = len(buffered)
+	}
+
+	u.logErr("%s%s (resync: discarding %d bytes)", c.prefix, err.Error(), skip)
+	return skip
+}
+
+func (u *Upstream) processRecvMsg(c *connection, msg spec.Msg, msgRaw []byte) {
+	netMgmtResp := u.handleNetworkMgmt(c, msg)
+
 	if autoResp := u.spec.AutoResp(msg); autoResp != nil {
-		u.sendAutoResp(autoResp)
+		u.sendAutoResp(c, autoResp)
+		return
+	}
+
+	if netMgmtResp != nil {
+		u.sendAutoResp(c, netMgmtResp)
 		return
 	}
 
@@ -49,32 +88,34 @@ func (u *Upstream) processRecvMsg(msg spec.Msg, msgRaw []byte) {
 
 	if s != nil {
 		s.setOk(msg, msgRaw)
+		u.pool.untrack(s)
 	}
 }
 
-func (u *Upstream) sendAutoResp(msg spec.Msg) {
-	msgRaw, err := u.spec.MsgEncode(msg)
-	if err != nil {
-		panic("spec error: MsgEncode auto resp:" + err.Error())
-	}
-
-	s := newSubmission(u.spec.MsgID(msg), msg, msgRaw, true)
+// processRejectedMsg fail the request that rejected message is the response of, e.g. because of invalid MAC,
+// rejected request from the host is not responded
+func (u *Upstream) processRejectedMsg(rejected *spec.ErrRejected) {
+	id := u.spec.MsgID(rejected.Msg)
 
 	u.submission.data.lock.Lock()
-	u.submission.data.data[s.id] = s
+	s := u.submission.data.data[id]
+	delete(u.submission.data.data, id)
 	u.submission.data.lock.Unlock()
 
-	removeSubmission := func(err error) {
-		s.setErr(err)
-		u.submission.data.lock.Lock()
-		delete(u.submission.data.data, s.id)
-		u.submission.data.lock.Unlock()
+	if s != nil {
+		s.setErr(rejected.Cause)
+		u.pool.untrack(s)
 	}
+}
+
+// sendAutoResp send msg through c, the connection where the request is received
+func (u *Upstream) sendAutoResp(c *connection, msg spec.Msg) {
+	s := newSubmission("", msg, true)
 
 	select {
-	case <-u.lifetimeCtx.Done():
-		removeSubmission(u.lifetimeCtx.Err())
+	case <-c.ctx.Done():
+		u.removeSubmission(s, c.ctx.Err())
 		return
-	case u.submission.priorityNotify <- s:
+	case c.priorityNotify <- s:
 	}
 }
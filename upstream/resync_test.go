@@ -0,0 +1,69 @@
+package upstream_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+// resyncSpec is RefSpec that resync to the next length header followed by response MTI
+type resyncSpec struct {
+	spec.Spec
+}
+
+func (resyncSpec) Resync(buffered []byte) int {
+	for i := 1; i+8 <= len(buffered); i++ {
+		header, mti := buffered[i:i+4], string(buffered[i+4:i+8])
+		if len(bytes.Trim(header, "0123456789")) == 0 && (mti == "0210" || mti == "0810") {
+			return i
+		}
+	}
+	return len(buffered)
+}
+
+func TestResync(t *testing.T) {
+	// the response is preceded by garbage or by the header of oversized message in the same write
+	junk := map[string]string{
+		"000001": "XXXX" + strings.Repeat("#", 100),
+		"000002": "9999" + strings.Repeat("#", 600),
+	}
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		res := approve(hc, msg)
+		prefix, ok := junk[msg[11]]
+		if msg[0] != "0200" || !ok {
+			return res
+		}
+		encoded, _ := (&spectest.RefSpec{}).MsgEncode(res)
+		hc.lock.Lock()
+		defer hc.lock.Unlock()
+		hc.conn.Write(append([]byte(prefix), encoded...))
+		return nil
+	})
+
+	l := newTestLogger(t)
+	u := build(t, upstream.NewBuilder().
+		WithTarget(h.addr).
+		WithSpec(resyncSpec{&spectest.RefSpec{}}).
+		WithLogger(l.info, l.err).
+		WithReadLimits(spec.Limits{MaxMessageSize: 512}))
+
+	for _, stan := range []string{"000001", "000002", "000003"} {
+		res, err := process(u, request(stan))
+		if err != nil || res[39] != "00" {
+			t.Fatalf("invalid process: %v %v", res, err)
+		}
+	}
+
+	if h.connCount() != 1 {
+		t.Fatalf("invalid state: connection is dropped, %d connections", h.connCount())
+	}
+	for _, expected := range []string{"decode error: invalid length header (resync", "message too large: at least 10003 bytes, limit is 512 bytes (resync"} {
+		if !l.hasErr(expected) {
+			t.Fatalf("invalid state: %q is not logged", expected)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package seq
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/payfazz/iso8585-utility-lib/internal/atomicfile"
+)
+
+// FileStore is Store backed by a json file.
+// Every Save rewrite the whole file atomically (write to temporary file, then rename).
+type FileStore struct {
+	path string
+
+	lock  sync.Mutex
+	state map[string]State
+}
+
+// NewFileStore open FileStore at path, the file is created on first Save if it doesn't exist.
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{
+		path:  path,
+		state: make(map[string]State),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return f, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &f.state); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Load .
+func (f *FileStore) Load(key string) (State, bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	state, ok := f.state[key]
+	return state, ok, nil
+}
+
+// Save .
+func (f *FileStore) Save(key string, state State) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	old, hadOld := f.state[key]
+	f.state[key] = state
+
+	if err := f.flush(); err != nil {
+		if hadOld {
+			f.state[key] = old
+		} else {
+			delete(f.state, key)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (f *FileStore) flush() error {
+	data, err := json.Marshal(f.state)
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(f.path, data)
+}
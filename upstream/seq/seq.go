@@ -0,0 +1,178 @@
+// Package seq provide thread-safe generator for STAN (field 11) and RRN (field 37).
+package seq
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MaxValue is the maximum value of a sequence, the next value after it is 1.
+const MaxValue = 999999
+
+// State is the persisted state of one sequence.
+type State struct {
+	// Value is the last generated value
+	Value int `json:"value"`
+
+	// Day is the day when Value was generated (YYYY-MM-DD), used for daily reset
+	Day string `json:"day"`
+}
+
+// Store persist State of sequences, so they doesn't reset on restart.
+type Store interface {
+	Load(key string) (state State, found bool, err error)
+	Save(key string, state State) error
+}
+
+type counter struct {
+	prefix string
+	store  Store
+
+	dailyReset bool
+	location   *time.Location
+	now        func() time.Time
+
+	lock  sync.Mutex
+	cache map[string]State
+}
+
+func newCounter(prefix string, store Store) counter {
+	return counter{
+		prefix:   prefix,
+		store:    store,
+		location: time.Local,
+		now:      time.Now,
+		cache:    make(map[string]State),
+	}
+}
+
+// next return the next value that skip returns false (skip can be nil),
+// the state is saved once, after the skipped values
+func (c *counter) next(key string, skip func(v int) bool) (int, time.Time, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key = c.prefix + key
+	now := c.now().In(c.location)
+	today := now.Format("2006-01-02")
+
+	state, ok := c.cache[key]
+	if !ok && c.store != nil {
+		var err error
+		state, _, err = c.store.Load(key)
+		if err != nil {
+			return 0, time.Time{}, fmt.Errorf("cannot load sequence %q: %s", key, err.Error())
+		}
+	}
+
+	if c.dailyReset && state.Day != today {
+		state.Value = 0
+	}
+
+	found := false
+	for i := 0; i < MaxValue; i++ {
+		state.Value++
+		if state.Value > MaxValue || state.Value < 1 {
+			state.Value = 1
+		}
+		if skip == nil || !skip(state.Value) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return 0, time.Time{}, fmt.Errorf("all values of sequence %q are skipped", key)
+	}
+	state.Day = today
+
+	if c.store != nil {
+		if err := c.store.Save(key, state); err != nil {
+			return 0, time.Time{}, fmt.Errorf("cannot save sequence %q: %s", key, err.Error())
+		}
+	}
+	c.cache[key] = state
+
+	return state.Value, now, nil
+}
+
+// STAN generate 6 digits system trace audit number, with independent sequence per terminal.
+type STAN struct {
+	c counter
+}
+
+// NewSTAN create STAN generator, store can be nil, in that case the sequence is only kept in memory.
+func NewSTAN(store Store) *STAN {
+	return &STAN{c: newCounter("stan/", store)}
+}
+
+// WithDailyReset make the sequence restart from 1 on the first Next call of each day in location loc.
+func (s *STAN) WithDailyReset(loc *time.Location) *STAN {
+	s.c.dailyReset = true
+	if loc != nil {
+		s.c.location = loc
+	}
+	return s
+}
+
+// WithClock override time.Now, useful for testing.
+func (s *STAN) WithClock(now func() time.Time) *STAN {
+	s.c.now = now
+	return s
+}
+
+// Next return next STAN for terminal.
+func (s *STAN) Next(terminal string) (string, error) {
+	return s.NextSkip(terminal, nil)
+}
+
+// NextSkip is like Next, but skip STAN that skip returns true, e.g. STAN that is still in flight.
+// The store is only saved once, with the returned STAN.
+// skip is called while holding the lock of the generator, so it must not call the generator.
+func (s *STAN) NextSkip(terminal string, skip func(stan string) bool) (string, error) {
+	var skipValue func(v int) bool
+	if skip != nil {
+		skipValue = func(v int) bool { return skip(fmt.Sprintf("%06d", v)) }
+	}
+	v, _, err := s.c.next(terminal, skipValue)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", v), nil
+}
+
+// RRN generate 12 characters retrieval reference number in YDDDHHNNNNNN format,
+// where Y is last digit of the year, DDD is day of the year, HH is the hour, and NNNNNN is the sequence.
+// The sequence is shared by every terminal, so RRN is unique across terminals.
+type RRN struct {
+	c counter
+}
+
+// NewRRN create RRN generator, store can be nil, in that case the sequence is only kept in memory.
+func NewRRN(store Store) *RRN {
+	return &RRN{c: newCounter("rrn/", store)}
+}
+
+// WithDailyReset make the sequence restart from 1 on the first Next call of each day in location loc.
+func (r *RRN) WithDailyReset(loc *time.Location) *RRN {
+	r.c.dailyReset = true
+	if loc != nil {
+		r.c.location = loc
+	}
+	return r
+}
+
+// WithClock override time.Now, useful for testing.
+func (r *RRN) WithClock(now func() time.Time) *RRN {
+	r.c.now = now
+	return r
+}
+
+// Next .
+func (r *RRN) Next() (string, error) {
+	v, now, err := r.c.next("", nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d%03d%02d%06d", now.Year()%10, now.YearDay(), now.Hour(), v), nil
+}
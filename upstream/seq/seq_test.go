@@ -0,0 +1,160 @@
+package seq_test
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/seq"
+)
+
+func TestSTANWrapAround(t *testing.T) {
+	store, err := seq.NewFileStore(filepath.Join(t.TempDir(), "seq.json"))
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if err := store.Save("stan/TERM0001", seq.State{Value: seq.MaxValue - 1}); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+
+	gen := seq.NewSTAN(store)
+	for _, expected := range []string{"999999", "000001", "000002"} {
+		stan, err := gen.Next("TERM0001")
+		if err != nil {
+			t.Fatalf("invalid err: %s", err.Error())
+		}
+		if stan != expected {
+			t.Fatalf("invalid stan: %s, expected %s", stan, expected)
+		}
+	}
+}
+
+func TestSTANPerTerminal(t *testing.T) {
+	gen := seq.NewSTAN(nil)
+	a1, _ := gen.Next("TERM0001")
+	a2, _ := gen.Next("TERM0001")
+	b1, _ := gen.Next("TERM0002")
+	if a1 != "000001" || a2 != "000002" || b1 != "000001" {
+		t.Fatalf("invalid stan: %s %s %s", a1, a2, b1)
+	}
+}
+
+func TestSTANConcurrent(t *testing.T) {
+	gen := seq.NewSTAN(nil)
+
+	var lock sync.Mutex
+	seen := make(map[string]struct{})
+
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				stan, _ := gen.Next("")
+				lock.Lock()
+				seen[stan] = struct{}{}
+				lock.Unlock()
+			}
+		}()
+	}
+	wait.Wait()
+
+	if len(seen) != 800 {
+		t.Fatalf("invalid stan: duplicate generated")
+	}
+}
+
+func TestSTANDailyReset(t *testing.T) {
+	now := time.Date(2026, 10, 19, 23, 59, 0, 0, time.UTC)
+	gen := seq.NewSTAN(nil).WithDailyReset(time.UTC).WithClock(func() time.Time { return now })
+
+	gen.Next("")
+	stan, _ := gen.Next("")
+	if stan != "000002" {
+		t.Fatalf("invalid stan: %s", stan)
+	}
+
+	now = now.Add(2 * time.Minute)
+	stan, _ = gen.Next("")
+	if stan != "000001" {
+		t.Fatalf("invalid stan: %s", stan)
+	}
+}
+
+func TestSTANNextSkip(t *testing.T) {
+	gen := seq.NewSTAN(nil)
+	inFlight := map[string]bool{"000001": true, "000002": true}
+	stan, err := gen.NextSkip("", func(stan string) bool { return inFlight[stan] })
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if stan != "000003" {
+		t.Fatalf("invalid stan: %s", stan)
+	}
+}
+
+type countingStore struct {
+	seq.Store
+	saves int
+}
+
+func (s *countingStore) Save(key string, state seq.State) error {
+	s.saves++
+	return s.Store.Save(key, state)
+}
+
+func TestSTANNextSkipSaveOnce(t *testing.T) {
+	fileStore, err := seq.NewFileStore(filepath.Join(t.TempDir(), "seq.json"))
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	store := &countingStore{Store: fileStore}
+	gen := seq.NewSTAN(store)
+
+	stan, err := gen.NextSkip("TERM0001", func(stan string) bool { return stan < "001000" })
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if stan != "001000" || store.saves != 1 {
+		t.Fatalf("invalid stan %s or save count %d", stan, store.saves)
+	}
+
+	stan, _ = seq.NewSTAN(fileStore).Next("TERM0001")
+	if stan != "001001" {
+		t.Fatalf("invalid stan after restart: %s", stan)
+	}
+}
+
+func TestFileStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seq.json")
+
+	store, _ := seq.NewFileStore(path)
+	gen := seq.NewSTAN(store)
+	gen.Next("TERM0001")
+	gen.Next("TERM0001")
+
+	store, err := seq.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	stan, _ := seq.NewSTAN(store).Next("TERM0001")
+	if stan != "000003" {
+		t.Fatalf("invalid stan after restart: %s", stan)
+	}
+}
+
+func TestRRN(t *testing.T) {
+	now := time.Date(2026, 2, 3, 14, 0, 0, 0, time.UTC)
+	gen := seq.NewRRN(nil).WithDailyReset(time.UTC).WithClock(func() time.Time { return now })
+	for _, expected := range []string{"603414000001", "603414000002"} {
+		rrn, err := gen.Next()
+		if err != nil {
+			t.Fatalf("invalid err: %s", err.Error())
+		}
+		if rrn != expected {
+			t.Fatalf("invalid rrn: %s, expected %s", rrn, expected)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+)
+
+var socks5Replies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect ask socks5 proxy (RFC 1928) on conn to connect to t.addr,
+// using username/password authentication (RFC 1929) when the proxy url has user info.
+// With socks5 scheme the target host is resolved locally (preferring IPv4 address), with socks5h it is resolved by the proxy.
+func socks5Connect(ctx context.Context, conn net.Conn, t *target) error {
+	host, portStr, err := net.SplitHostPort(t.addr)
+	if err != nil {
+		return fmt.Errorf("socks5 proxy: %s", err.Error())
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("socks5 proxy: invalid port: %s", portStr)
+	}
+
+	user := t.proxy.endpoint.User.Username()
+	pass, _ := t.proxy.endpoint.User.Password()
+	if len(user) > 255 || len(pass) > 255 {
+		return fmt.Errorf("socks5 proxy: username or password too long")
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil || t.proxy.endpoint.Scheme == "socks5" {
+		if ip == nil {
+			addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return fmt.Errorf("socks5 proxy: %s", err.Error())
+			}
+			ip = preferIPv4(addrs)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, 1)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, 4)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("socks5 proxy: host name too long")
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+
+	// background goroutine to force close connection, same as http proxy CONNECT
+	// dialState:
+	// 0 => not done, waiting
+	// 1 => done
+	// 2 => not done, timed up, will be closed
+	dialState := int32(0)
+	go func() {
+		<-ctx.Done()
+		if atomic.CompareAndSwapInt32(&dialState, 0, 2) {
+			conn.Close()
+		}
+	}()
+
+	if err := socks5Handshake(conn, user, pass, req); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timeout")
+		}
+		return fmt.Errorf("socks5 proxy: %s", err.Error())
+	}
+
+	if !atomic.CompareAndSwapInt32(&dialState, 0, 1) {
+		return fmt.Errorf("socks5 proxy: timeout")
+	}
+
+	return nil
+}
+
+// preferIPv4 return the first IPv4 address in addrs, or the first address if there is none,
+// since IPv6 target is often unreachable through the proxy even when the host name resolve to it
+func preferIPv4(addrs []net.IPAddr) net.IP {
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return addr.IP
+		}
+	}
+	return addrs[0].IP
+}
+
+func socks5Handshake(rw io.ReadWriter, user, pass string, req []byte) error {
+	method := byte(0)
+	if user != "" || pass != "" {
+		method = 2
+	}
+	if _, err := rw.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+
+	buff := make([]byte, 2)
+	if _, err := io.ReadFull(rw, buff); err != nil {
+		return err
+	}
+	if buff[0] != 5 {
+		return fmt.Errorf("invalid version: %d", buff[0])
+	}
+	if buff[1] != method {
+		return fmt.Errorf("authentication method not accepted")
+	}
+
+	if method == 2 {
+		auth := []byte{1, byte(len(user))}
+		auth = append(auth, user...)
+		auth = append(auth, byte(len(pass)))
+		auth = append(auth, pass...)
+		if _, err := rw.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(rw, buff); err != nil {
+			return err
+		}
+		if buff[1] != 0 {
+			return fmt.Errorf("authentication failed")
+		}
+	}
+
+	if _, err := rw.Write(req); err != nil {
+		return err
+	}
+
+	buff = make([]byte, 4)
+	if _, err := io.ReadFull(rw, buff); err != nil {
+		return err
+	}
+	if buff[0] != 5 {
+		return fmt.Errorf("invalid version: %d", buff[0])
+	}
+	if buff[1] != 0 {
+		if msg, ok := socks5Replies[buff[1]]; ok {
+			return fmt.Errorf("%s", msg)
+		}
+		return fmt.Errorf("unknown reply: %d", buff[1])
+	}
+
+	// discard bound address and port
+	var n int
+	switch buff[3] {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		if _, err := io.ReadFull(rw, buff[:1]); err != nil {
+			return err
+		}
+		n = int(buff[0])
+	default:
+		return fmt.Errorf("invalid address type: %d", buff[3])
+	}
+	if _, err := io.ReadFull(rw, make([]byte, n+2)); err != nil {
+		return err
+	}
+
+	return nil
+}
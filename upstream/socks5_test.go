@@ -0,0 +1,283 @@
+package upstream_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+// socks5Server is minimal SOCKS5 server with username/password authentication
+type socks5Server struct {
+	addr       string
+	user, pass string
+	resolve    map[string]string
+	hosts      chan string
+}
+
+func serveSOCKS5(t *testing.T, user, pass string, resolve map[string]string) *socks5Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("invalid listen: %s", err.Error())
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &socks5Server{addr: ln.Addr().String(), user: user, pass: pass, resolve: resolve, hosts: make(chan string, 16)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	buff := make([]byte, 256)
+	read := func(n int) []byte {
+		if _, err := io.ReadFull(conn, buff[:n]); err != nil {
+			return nil
+		}
+		return buff[:n]
+	}
+
+	if b := read(2); b == nil || b[0] != 5 {
+		return
+	}
+	methods := read(int(buff[1]))
+	if methods == nil {
+		return
+	}
+	method := byte(0xFF)
+	for _, m := range methods {
+		if (s.user == "" && m == 0) || (s.user != "" && m == 2) {
+			method = m
+		}
+	}
+	conn.Write([]byte{5, method})
+	if method == 0xFF {
+		return
+	}
+
+	if method == 2 {
+		if read(2) == nil {
+			return
+		}
+		user := string(read(int(buff[1])))
+		if read(1) == nil {
+			return
+		}
+		pass := string(read(int(buff[0])))
+		if user != s.user || pass != s.pass {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	if b := read(4); b == nil || b[1] != 1 {
+		return
+	}
+	var host string
+	switch buff[3] {
+	case 1:
+		host = net.IP(append([]byte(nil), read(4)...)).String()
+	case 4:
+		host = net.IP(append([]byte(nil), read(16)...)).String()
+	case 3:
+		host = string(read(int(read(1)[0])))
+	default:
+		return
+	}
+	port := read(2)
+	if port == nil {
+		return
+	}
+	s.hosts <- host
+
+	addr := host
+	if v, ok := s.resolve[host]; ok {
+		addr = v
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(addr, strconv.Itoa(int(port[0])<<8|int(port[1]))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func processVia(t *testing.T, target upstream.Target) (spec.Msg, error) {
+	u, err := upstream.NewBuilder().
+		WithTargets(upstream.PolicyFailover, target).
+		WithSpec(&spectest.RefSpec{}).
+		WithCircuitBreaker(1).
+		Build()
+	if err != nil {
+		t.Fatalf("invalid build: %s", err.Error())
+	}
+	defer u.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return u.Process(ctx, spec.Msg{0: "0200", 3: "000000", 11: "000001", 41: "TERM0001"})
+}
+
+func TestSOCKS5(t *testing.T) {
+	host := serveHost(t)
+	_, port, _ := net.SplitHostPort(host)
+	proxy := serveSOCKS5(t, "user", "secret", map[string]string{"iso-host.example": "127.0.0.1"})
+
+	for _, tc := range []struct {
+		scheme   string
+		addr     string
+		expected string
+	}{
+		{"socks5", host, "127.0.0.1"},
+		{"socks5", net.JoinHostPort("localhost", port), "127.0.0.1"},
+		{"socks5h", net.JoinHostPort("iso-host.example", port), "iso-host.example"},
+	} {
+		res, err := processVia(t, upstream.Target{
+			Addr:  tc.addr,
+			Proxy: &url.URL{Scheme: tc.scheme, User: url.UserPassword("user", "secret"), Host: proxy.addr},
+		})
+		if err != nil {
+			t.Fatalf("invalid process via %s to %s: %s", tc.scheme, tc.addr, err.Error())
+		}
+		if res[39] != "00" {
+			t.Fatalf("invalid response: %v", res)
+		}
+		if h := <-proxy.hosts; h != tc.expected {
+			t.Fatalf("invalid host received by proxy: %s", h)
+		}
+	}
+}
+
+func TestSOCKS5Failure(t *testing.T) {
+	host := serveHost(t)
+	proxy := serveSOCKS5(t, "user", "secret", nil)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("invalid listen: %s", err.Error())
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	for _, tc := range []struct {
+		target   upstream.Target
+		expected string
+	}{
+		{
+			upstream.Target{Addr: host, Proxy: &url.URL{Scheme: "socks5", User: url.UserPassword("user", "wrong"), Host: proxy.addr}},
+			"authentication failed",
+		},
+		{
+			upstream.Target{Addr: host, Proxy: &url.URL{Scheme: "socks5", Host: proxy.addr}},
+			"authentication method not accepted",
+		},
+		{
+			upstream.Target{Addr: closedAddr, Proxy: &url.URL{Scheme: "socks5", User: url.UserPassword("user", "secret"), Host: proxy.addr}},
+			"connection refused",
+		},
+	} {
+		_, err := processVia(t, tc.target)
+		var unavailable *upstream.ErrUpstreamUnavailable
+		if !errors.As(err, &unavailable) {
+			t.Fatalf("invalid error: %v", err)
+		}
+		if !strings.Contains(unavailable.LastErr.Error(), "socks5 proxy: "+tc.expected) {
+			t.Fatalf("invalid last error: %s", unavailable.LastErr.Error())
+		}
+	}
+}
+
+func TestSOCKS5Timeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("invalid listen: %s", err.Error())
+	}
+	defer ln.Close()
+
+	// accept but never reply
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	u, err := upstream.NewBuilder().
+		WithTargets(upstream.PolicyFailover, upstream.Target{Addr: "127.0.0.1:1", Proxy: &url.URL{Scheme: "socks5h", Host: ln.Addr().String()}}).
+		WithSpec(&spectest.RefSpec{}).
+		WithDialTimeout(100 * time.Millisecond).
+		WithCircuitBreaker(1).
+		Build()
+	if err != nil {
+		t.Fatalf("invalid build: %s", err.Error())
+	}
+	defer u.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var unavailable *upstream.ErrUpstreamUnavailable
+	for {
+		_, err := u.Process(ctx, spec.Msg{0: "0200", 3: "000000", 11: "000001", 41: "TERM0001"})
+		if errors.As(err, &unavailable) {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("invalid error: %v", err)
+		}
+	}
+	if unavailable.LastErr.Error() != "socks5 proxy: timeout" {
+		t.Fatalf("invalid last error: %s", unavailable.LastErr.Error())
+	}
+}
+
+func TestProxyScheme(t *testing.T) {
+	for _, scheme := range []string{"http", "https", "socks5", "SOCKS5H"} {
+		u, err := upstream.NewBuilder().
+			WithTarget("127.0.0.1:1").
+			WithProxy(&url.URL{Scheme: scheme, Host: "127.0.0.1"}, "").
+			WithSpec(&spectest.RefSpec{}).
+			Build()
+		if err != nil {
+			t.Fatalf("invalid build with %s proxy: %s", scheme, err.Error())
+		}
+		u.Close()
+	}
+
+	u, err := upstream.NewBuilder().
+		WithTarget("127.0.0.1:1").
+		WithProxy(&url.URL{Scheme: "socks4", Host: "127.0.0.1"}, "").
+		WithSpec(&spectest.RefSpec{}).
+		Build()
+	if err == nil {
+		u.Close()
+		t.Fatalf("invalid build with socks4 proxy: expecting error")
+	}
+}
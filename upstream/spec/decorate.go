@@ -0,0 +1,196 @@
+package spec
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// OnNewConnFunc is the signature of Spec.OnNewConn.
+type OnNewConnFunc func(ctx context.Context, conn net.Conn, readed []byte) (unprocessedData []byte, err error)
+
+// Hooks is cross-cutting behavior around a Spec, nil hook is skipped.
+type Hooks struct {
+	// BeforeEncode is called before MsgEncode, the returned message is encoded instead of msg.
+	// Upstream call it once per request, before picking the connection, see Preparer.
+	BeforeEncode func(msg Msg) (Msg, error)
+
+	// AfterEncode is called after MsgEncode succeed, the returned bytes is used instead of encoded.
+	// Upstream call it with the working keys of the connection the message is sent through.
+	AfterEncode func(msg Msg, encoded []byte) ([]byte, error)
+
+	// AfterDecode is called after MsgDecode produce a message, raw is the bytes consumed by it.
+	// The returned message is used instead of msg, the returned error reject the message, see ErrRejected.
+	AfterDecode func(msg Msg, raw []byte) (Msg, error)
+
+	// OnDecodeError is called when MsgDecode or AfterDecode failed.
+	OnDecodeError func(encoded []byte, err error)
+
+	// OnNewConn wrap Spec.OnNewConn, it must call next to run the wrapped implementation.
+	OnNewConn func(ctx context.Context, conn net.Conn, readed []byte, next OnNewConnFunc) (unprocessedData []byte, err error)
+
+	// OnWorkingKeys is called when the working keys of the connection changed, see KeyAware.
+	OnWorkingKeys func(keys *WorkingKeys)
+
+	// AutoResp is called with the request and the result of wrapped AutoResp (can be nil),
+	// the returned message is used instead of resp.
+	AutoResp func(req Msg, resp Msg) Msg
+}
+
+// Preparer is implemented by Spec returned by Decorate,
+// MsgEncode(msg) is equivalent to EncodePrepared(Prepare(msg)).
+type Preparer interface {
+	// Prepare run BeforeEncode hooks, outermost first.
+	Prepare(msg Msg) (Msg, error)
+
+	// EncodePrepared encode message returned by Prepare and run AfterEncode hooks, innermost first.
+	EncodePrepared(prepared Msg) ([]byte, error)
+}
+
+// Decorate wrap s with hooks, the first hooks is the outermost,
+// i.e. its BeforeEncode is called first and its AfterDecode is called last.
+func Decorate(s Spec, hooks ...Hooks) Spec {
+	for i := len(hooks) - 1; i >= 0; i-- {
+		s = &decorated{inner: s, hooks: hooks[i]}
+	}
+	return s
+}
+
+// Undecorate return the Spec that is wrapped by Decorate, or s itself if s is not decorated.
+func Undecorate(s Spec) Spec {
+	for {
+		d, ok := s.(*decorated)
+		if !ok {
+			return s
+		}
+		s = d.inner
+	}
+}
+
+// Stamp return Hooks that fill field with the value returned by gen before encode, if the message doesn't have it.
+// The message is cloned before modified.
+func Stamp(field int, gen func(msg Msg) (string, error)) Hooks {
+	return Hooks{
+		BeforeEncode: func(msg Msg) (Msg, error) {
+			if _, ok := msg[field]; ok {
+				return msg, nil
+			}
+			v, err := gen(msg)
+			if err != nil {
+				return nil, err
+			}
+			msg = msg.Clone()
+			msg[field] = v
+			return msg, nil
+		},
+	}
+}
+
+type decorated struct {
+	inner Spec
+	hooks Hooks
+}
+
+func (d *decorated) OnNewConn(ctx context.Context, conn net.Conn, readed []byte) ([]byte, error) {
+	if d.hooks.OnNewConn == nil {
+		return d.inner.OnNewConn(ctx, conn, readed)
+	}
+	return d.hooks.OnNewConn(ctx, conn, readed, d.inner.OnNewConn)
+}
+
+func (d *decorated) MsgEncode(decoded Msg) ([]byte, error) {
+	prepared, err := d.Prepare(decoded)
+	if err != nil {
+		return nil, err
+	}
+	return d.EncodePrepared(prepared)
+}
+
+func (d *decorated) Prepare(msg Msg) (Msg, error) {
+	if d.hooks.BeforeEncode != nil {
+		var err error
+		msg, err = d.hooks.BeforeEncode(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p, ok := d.inner.(Preparer); ok {
+		return p.Prepare(msg)
+	}
+	return msg, nil
+}
+
+func (d *decorated) EncodePrepared(prepared Msg) ([]byte, error) {
+	var encoded []byte
+	var err error
+	if p, ok := d.inner.(Preparer); ok {
+		encoded, err = p.EncodePrepared(prepared)
+	} else {
+		encoded, err = d.inner.MsgEncode(prepared)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if d.hooks.AfterEncode != nil {
+		return d.hooks.AfterEncode(prepared, encoded)
+	}
+	return encoded, nil
+}
+
+func (d *decorated) MsgDecode(encoded []byte) (int, Msg, int, error) {
+	advance, decoded, needMore, err := d.inner.MsgDecode(encoded)
+	if err == nil && needMore <= 0 && decoded != nil && d.hooks.AfterDecode != nil {
+		if 0 <= advance && advance <= len(encoded) {
+			hooked, hookErr := d.hooks.AfterDecode(decoded, encoded[:advance])
+			if hookErr != nil {
+				err = &ErrRejected{Msg: decoded, Cause: hookErr}
+			} else {
+				decoded = hooked
+			}
+		}
+	}
+	if err != nil {
+		if d.hooks.OnDecodeError != nil {
+			d.hooks.OnDecodeError(encoded, err)
+		}
+		if _, ok := err.(*ErrRejected); ok {
+			return advance, nil, 0, err
+		}
+		return 0, nil, 0, err
+	}
+	return advance, decoded, needMore, nil
+}
+
+func (d *decorated) Resync(buffered []byte) int {
+	if r, ok := d.inner.(Resyncer); ok {
+		return r.Resync(buffered)
+	}
+	return 0
+}
+
+func (d *decorated) SetWorkingKeys(keys *WorkingKeys) {
+	if d.hooks.OnWorkingKeys != nil {
+		d.hooks.OnWorkingKeys(keys)
+	}
+	if k, ok := d.inner.(KeyAware); ok {
+		k.SetWorkingKeys(keys)
+	}
+}
+
+func (d *decorated) MsgID(msg Msg) string {
+	return d.inner.MsgID(msg)
+}
+
+func (d *decorated) AutoResp(req Msg) Msg {
+	resp := d.inner.AutoResp(req)
+	if d.hooks.AutoResp != nil {
+		return d.hooks.AutoResp(req, resp)
+	}
+	return resp
+}
+
+func (d *decorated) GetPingMsg() (Msg, time.Duration) {
+	return d.inner.GetPingMsg()
+}
@@ -0,0 +1,184 @@
+package spec_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func TestDecorateOrder(t *testing.T) {
+	var calls []string
+	hooks := func(name string) spec.Hooks {
+		return spec.Hooks{
+			BeforeEncode: func(msg spec.Msg) (spec.Msg, error) {
+				calls = append(calls, "before-encode-"+name)
+				return msg, nil
+			},
+			AfterDecode: func(msg spec.Msg, raw []byte) (spec.Msg, error) {
+				calls = append(calls, "after-decode-"+name)
+				return msg, nil
+			},
+		}
+	}
+
+	s := spec.Decorate(lineSpec{}, hooks("a"), hooks("b"))
+	encoded, err := s.MsgEncode(spec.Msg{11: "000001"})
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if _, _, _, err := s.MsgDecode(encoded); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+
+	expected := "[before-encode-a before-encode-b after-decode-b after-decode-a]"
+	if fmt.Sprint(calls) != expected {
+		t.Fatalf("invalid order: %v", calls)
+	}
+
+	if _, ok := spec.Undecorate(s).(lineSpec); !ok {
+		t.Fatalf("invalid undecorate")
+	}
+}
+
+func TestDecorateStamp(t *testing.T) {
+	s := spec.Decorate(lineSpec{}, spec.Stamp(7, func(spec.Msg) (string, error) { return "1019103520", nil }))
+
+	msg := spec.Msg{11: "000001"}
+	encoded, err := s.MsgEncode(msg)
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if string(encoded) != "7=1019103520;11=000001\n" {
+		t.Fatalf("invalid encoded: %q", encoded)
+	}
+	if _, ok := msg[7]; ok {
+		t.Fatalf("original message must not be modified")
+	}
+
+	encoded, _ = s.MsgEncode(spec.Msg{7: "0101000000", 11: "000001"})
+	if string(encoded) != "7=0101000000;11=000001\n" {
+		t.Fatalf("invalid encoded: %q", encoded)
+	}
+}
+
+func TestDecoratePrepare(t *testing.T) {
+	var afterEncode spec.Msg
+	s := spec.Decorate(lineSpec{},
+		spec.Stamp(11, func(spec.Msg) (string, error) { return "000001", nil }),
+		spec.Hooks{
+			AfterEncode: func(msg spec.Msg, encoded []byte) ([]byte, error) {
+				afterEncode = msg
+				return append([]byte("H"), encoded...), nil
+			},
+		},
+		spec.Stamp(7, func(spec.Msg) (string, error) { return "1019103520", nil }),
+	)
+
+	p, ok := s.(spec.Preparer)
+	if !ok {
+		t.Fatalf("decorated spec must be Preparer")
+	}
+	prepared, err := p.Prepare(spec.Msg{0: "0200"})
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{0: "0200", 7: "1019103520", 11: "000001"}, prepared)
+
+	encoded, err := p.EncodePrepared(prepared)
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	spectest.AssertMsgEqual(t, prepared, afterEncode)
+
+	direct, err := s.MsgEncode(spec.Msg{0: "0200"})
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if string(encoded) != string(direct) || string(direct) != "H0=0200;7=1019103520;11=000001\n" {
+		t.Fatalf("invalid encoded: %q %q", encoded, direct)
+	}
+}
+
+func TestDecorateDecodeError(t *testing.T) {
+	var errCount int
+	s := spec.Decorate(lineSpec{}, spec.Hooks{
+		AfterDecode: func(msg spec.Msg, raw []byte) (spec.Msg, error) {
+			if string(raw) != "11=000001\n" {
+				return nil, fmt.Errorf("invalid raw: %q", raw)
+			}
+			if msg[39] != "" {
+				return nil, fmt.Errorf("unexpected field 39")
+			}
+			return msg, nil
+		},
+		OnDecodeError: func(encoded []byte, err error) {
+			errCount++
+		},
+	})
+
+	advance, msg, _, err := s.MsgDecode([]byte("11=000001\n11=000002\n"))
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if advance != 10 {
+		t.Fatalf("invalid advance")
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{11: "000001"}, msg)
+
+	if _, _, _, err := s.MsgDecode([]byte("11=000001;39=00\n")); err == nil {
+		t.Fatalf("invalid err")
+	}
+	if _, _, _, err := s.MsgDecode([]byte("abc\n")); err == nil {
+		t.Fatalf("invalid err")
+	}
+	if errCount != 2 {
+		t.Fatalf("invalid error count: %d", errCount)
+	}
+}
+
+func TestDecorateAutoResp(t *testing.T) {
+	s := spec.Decorate(lineSpec{}, spec.Hooks{
+		AutoResp: func(req, resp spec.Msg) spec.Msg {
+			if resp == nil {
+				return nil
+			}
+			resp = resp.Clone()
+			resp[70] = "301"
+			return resp
+		},
+	})
+
+	spectest.AssertMsgEqual(t, spec.Msg{0: "0810", 11: "000001", 39: "00", 70: "301"}, s.AutoResp(spec.Msg{0: "0800", 11: "000001"}))
+	if s.AutoResp(spec.Msg{0: "0200"}) != nil {
+		t.Fatalf("invalid auto resp")
+	}
+}
+
+type keyAwareSpec struct {
+	lineSpec
+	spec.KeyHolder
+}
+
+func TestDecorateWorkingKeys(t *testing.T) {
+	var hookHolder spec.KeyHolder
+	inner := &keyAwareSpec{}
+	s := spec.Decorate(inner, hookHolder.Hooks(), spec.Hooks{})
+
+	keyAware, ok := s.(spec.KeyAware)
+	if !ok {
+		t.Fatalf("decorated spec must be KeyAware")
+	}
+
+	keys := &spec.WorkingKeys{PIN: []byte{1}, MAC: []byte{2}}
+	keyAware.SetWorkingKeys(keys)
+	if hookHolder.WorkingKeys() != keys || inner.WorkingKeys() != keys {
+		t.Fatalf("invalid working keys")
+	}
+
+	keyAware.SetWorkingKeys(nil)
+	if hookHolder.WorkingKeys() != nil || inner.WorkingKeys() != nil {
+		t.Fatalf("invalid working keys")
+	}
+}
@@ -0,0 +1,139 @@
+package spec
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EchoFields is the default list of fields that a response must echo from its request.
+var EchoFields = []int{2, 3, 4, 7, 11, 12, 13, 37, 41, 42}
+
+// MsgDiff .
+type MsgDiff struct {
+	Added   []int
+	Removed []int
+	Changed []int
+}
+
+// Empty .
+func (d MsgDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff compare m to other, Added contains fields only exists in other,
+// Removed contains fields only exists in m, Changed contains fields exists in both but with different value.
+// All of them are sorted.
+func (m Msg) Diff(other Msg) MsgDiff {
+	var d MsgDiff
+	for k, v := range m {
+		if ov, ok := other[k]; !ok {
+			d.Removed = append(d.Removed, k)
+		} else if ov != v {
+			d.Changed = append(d.Changed, k)
+		}
+	}
+	for k := range other {
+		if _, ok := m[k]; !ok {
+			d.Added = append(d.Added, k)
+		}
+	}
+	sort.Ints(d.Added)
+	sort.Ints(d.Removed)
+	sort.Ints(d.Changed)
+	return d
+}
+
+// ErrEchoMismatch .
+type ErrEchoMismatch struct {
+	Fields []int
+}
+
+func (e *ErrEchoMismatch) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = strconv.Itoa(f)
+	}
+	return "echo fields mismatch: " + strings.Join(fields, ",")
+}
+
+// CheckEcho check that every field in fields that present in req is present in resp with the same value.
+// If fields is empty, EchoFields is used.
+// The returned error is *ErrEchoMismatch.
+func CheckEcho(req, resp Msg, fields ...int) error {
+	if len(fields) == 0 {
+		fields = EchoFields
+	}
+
+	var mismatch []int
+	for _, f := range fields {
+		v, ok := req[f]
+		if !ok {
+			continue
+		}
+		if rv, ok := resp[f]; !ok || rv != v {
+			mismatch = append(mismatch, f)
+		}
+	}
+
+	if len(mismatch) > 0 {
+		sort.Ints(mismatch)
+		return &ErrEchoMismatch{Fields: mismatch}
+	}
+	return nil
+}
+
+// Redact return a version of value of field that is safe to be printed in logs and test output.
+//
+// PAN (2) only keep first 6 and last 4 digits, expiration date (14), track data (35, 36, 45) and PIN block (52) are fully masked,
+// value that is not printable ascii is written in hex.
+func Redact(field int, value string) string {
+	switch field {
+	case 2:
+		if len(value) < 13 {
+			return strings.Repeat("*", len(value))
+		}
+		return value[:6] + strings.Repeat("*", len(value)-10) + value[len(value)-4:]
+	case 14, 35, 36, 45, 52:
+		return "<redacted>"
+	}
+
+	if !isPrintable(value) {
+		return "0x" + strings.ToUpper(hex.EncodeToString([]byte(value)))
+	}
+	return strconv.Quote(value)
+}
+
+// FormatDiff return human readable field-by-field difference between want and got, with values passed through Redact.
+// It returns empty string if both messages are equal.
+func FormatDiff(want, got Msg) string {
+	d := want.Diff(got)
+	if d.Empty() {
+		return ""
+	}
+
+	type line struct {
+		field int
+		text  string
+	}
+	var lines []line
+	for _, f := range d.Removed {
+		lines = append(lines, line{f, fmt.Sprintf("- %3d: %s", f, Redact(f, want[f]))})
+	}
+	for _, f := range d.Added {
+		lines = append(lines, line{f, fmt.Sprintf("+ %3d: %s", f, Redact(f, got[f]))})
+	}
+	for _, f := range d.Changed {
+		lines = append(lines, line{f, fmt.Sprintf("~ %3d: %s => %s", f, Redact(f, want[f]), Redact(f, got[f]))})
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].field < lines[j].field })
+
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l.text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package spec_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+func TestDiff(t *testing.T) {
+	a := spec.Msg{0: "0200", 3: "000000", 4: "000000010000", 11: "000001"}
+	b := spec.Msg{0: "0210", 3: "000000", 11: "000001", 39: "00", 38: "ABC123"}
+
+	d := a.Diff(b)
+	if !reflect.DeepEqual(d.Added, []int{38, 39}) {
+		t.Fatalf("invalid added: %v", d.Added)
+	}
+	if !reflect.DeepEqual(d.Removed, []int{4}) {
+		t.Fatalf("invalid removed: %v", d.Removed)
+	}
+	if !reflect.DeepEqual(d.Changed, []int{0}) {
+		t.Fatalf("invalid changed: %v", d.Changed)
+	}
+	if d.Empty() {
+		t.Fatalf("invalid empty")
+	}
+	if !a.Diff(a.Clone()).Empty() {
+		t.Fatalf("invalid empty")
+	}
+}
+
+func TestCheckEcho(t *testing.T) {
+	req := spec.Msg{0: "0200", 2: "4111111111111111", 3: "000000", 11: "000001", 41: "TERM0001"}
+	resp := spec.Msg{0: "0210", 2: "4111111111111111", 3: "000000", 11: "000001", 41: "TERM0001", 39: "00"}
+	if err := spec.CheckEcho(req, resp); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+
+	resp[11] = "000002"
+	delete(resp, 41)
+	err := spec.CheckEcho(req, resp)
+	mismatch, ok := err.(*spec.ErrEchoMismatch)
+	if !ok {
+		t.Fatalf("invalid err: %#v", err)
+	}
+	if !reflect.DeepEqual(mismatch.Fields, []int{11, 41}) {
+		t.Fatalf("invalid mismatch: %v", mismatch.Fields)
+	}
+
+	if err := spec.CheckEcho(req, resp, 2, 3); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+}
+
+func TestFormatDiff(t *testing.T) {
+	want := spec.Msg{0: "0210", 2: "4111111111111111", 39: "00", 52: "\x01\x02", 62: "\x00\xFF"}
+	got := spec.Msg{0: "0210", 2: "4111112222221111", 38: "ABC123", 52: "\x03\x04", 62: "\x00\xFE"}
+
+	expected := "" +
+		"~   2: 411111******1111 => 411111******1111\n" +
+		"+  38: \"ABC123\"\n" +
+		"-  39: \"00\"\n" +
+		"~  52: <redacted> => <redacted>\n" +
+		"~  62: 0x00FF => 0x00FE\n"
+	if output := spec.FormatDiff(want, got); output != expected {
+		t.Fatalf("invalid diff:\n%s", output)
+	}
+
+	if output := spec.FormatDiff(want, want.Clone()); output != "" {
+		t.Fatalf("invalid diff:\n%s", output)
+	}
+}
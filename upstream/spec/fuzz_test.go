@@ -0,0 +1,80 @@
+package spec_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+// chunkReader deliver data in chunks with size taken from sizes
+type chunkReader struct {
+	data  []byte
+	sizes []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := len(r.data)
+	if len(r.sizes) > 0 {
+		n = int(r.sizes[0])%len(r.data) + 1
+		r.sizes = r.sizes[1:]
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func FuzzReadOneMessage(f *testing.F) {
+	s := &spectest.RefSpec{}
+	for _, msg := range []spec.Msg{
+		{0: "0200", 2: "4111111111111111", 3: "000000", 4: "000000010000", 7: "1019103520", 11: "000001", 41: "TERM0001"},
+		{0: "0210", 3: "000000", 7: "1019103520", 11: "000001", 39: "00", 41: "TERM0001", 102: "1234567890"},
+		{0: "0800", 7: "1019103520", 11: "000002", 70: "301"},
+	} {
+		encoded, err := s.MsgEncode(msg)
+		if err != nil {
+			f.Fatalf("invalid seed: %s", err.Error())
+		}
+		f.Add(encoded, []byte{})
+		f.Add(encoded, []byte{0, 0, 0, 0, 0, 5, 1})
+		f.Add(append(encoded, encoded...), []byte{3, 200})
+		f.Add(encoded[:len(encoded)-1], []byte{})
+	}
+	f.Add([]byte("9999"), []byte{})
+	f.Add([]byte("0004ABCD"), []byte{})
+	f.Add([]byte("-001"), []byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte, sizes []byte) {
+		r := &chunkReader{data: append([]byte(nil), data...), sizes: sizes}
+
+		var buffer []byte
+		bufferLen := 0
+		consumed := 0
+		for {
+			msg, raw, newBuffer, newBufferLen, err := spec.ReadOneMessage(s, r, buffer, bufferLen)
+			if err != nil {
+				return
+			}
+			buffer, bufferLen = newBuffer, newBufferLen
+
+			if msg == nil {
+				t.Fatalf("nil message without error")
+			}
+			if len(raw) == 0 || consumed+len(raw) > len(data) || !bytes.Equal(raw, data[consumed:consumed+len(raw)]) {
+				t.Fatalf("invalid raw message")
+			}
+			consumed += len(raw)
+			if bufferLen < 0 || bufferLen > len(buffer) || consumed+bufferLen > len(data) {
+				t.Fatalf("invalid buffer state")
+			}
+		}
+	})
+}
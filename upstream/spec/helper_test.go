@@ -0,0 +1,70 @@
+package spec_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+// lineSpec encode message as "field=value;field=value\n"
+type lineSpec struct{}
+
+func (lineSpec) OnNewConn(ctx context.Context, conn net.Conn, readed []byte) ([]byte, error) {
+	return readed, nil
+}
+
+func (lineSpec) MsgEncode(decoded spec.Msg) ([]byte, error) {
+	keys := make([]int, 0, len(decoded))
+	for k, v := range decoded {
+		if strings.ContainsAny(v, ";=\n") {
+			return nil, fmt.Errorf("invalid value")
+		}
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%d=%s", k, decoded[k])
+	}
+	return []byte(strings.Join(parts, ";") + "\n"), nil
+}
+
+func (lineSpec) MsgDecode(encoded []byte) (int, spec.Msg, int, error) {
+	end := bytes.IndexByte(encoded, '\n')
+	if end < 0 {
+		return 0, nil, 1, nil
+	}
+	msg := spec.Msg{}
+	if end > 0 {
+		for _, part := range strings.Split(string(encoded[:end]), ";") {
+			kv := strings.SplitN(part, "=", 2)
+			if len(kv) != 2 {
+				return 0, nil, 0, fmt.Errorf("invalid part")
+			}
+			k, err := strconv.Atoi(kv[0])
+			if err != nil {
+				return 0, nil, 0, err
+			}
+			msg[k] = kv[1]
+		}
+	}
+	return end + 1, msg, 0, nil
+}
+
+func (lineSpec) MsgID(msg spec.Msg) string { return msg[11] }
+
+func (lineSpec) AutoResp(req spec.Msg) spec.Msg {
+	if req[0] == "0800" {
+		return spec.Msg{0: "0810", 11: req[11], 39: "00"}
+	}
+	return nil
+}
+
+func (lineSpec) GetPingMsg() (spec.Msg, time.Duration) { return nil, 0 }
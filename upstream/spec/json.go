@@ -0,0 +1,132 @@
+package spec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const jsonMTIKey = "mti"
+
+// MarshalJSON encode the message into its canonical json form.
+//
+// Fields are written in ascending field number order, the MTI (field 0) is written under "mti" key,
+// and fields that are not printable ascii are written as {"hex":"..."} with uppercase hex digits.
+func (m Msg) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		if k < 0 {
+			return nil, fmt.Errorf("invalid field number: %d", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		if k == FieldMTI {
+			b.WriteString(strconv.Quote(jsonMTIKey))
+		} else {
+			b.WriteString(strconv.Quote(strconv.Itoa(k)))
+		}
+		b.WriteByte(':')
+
+		v := m[k]
+		if isPrintable(v) {
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			b.Write(encoded)
+		} else {
+			b.WriteString(`{"hex":"`)
+			b.WriteString(strings.ToUpper(hex.EncodeToString([]byte(v))))
+			b.WriteString(`"}`)
+		}
+	}
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
+
+// UnmarshalJSON decode the message from the form produced by MarshalJSON.
+func (m *Msg) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if raw == nil {
+		*m = nil
+		return nil
+	}
+
+	r := make(Msg, len(raw))
+	for k, v := range raw {
+		field := FieldMTI
+		if k != jsonMTIKey {
+			n, err := strconv.Atoi(k)
+			if err != nil || n <= 0 || strconv.Itoa(n) != k {
+				return fmt.Errorf("invalid field number: %q", k)
+			}
+			field = n
+		}
+
+		value, err := unmarshalJSONField(v)
+		if err != nil {
+			return fmt.Errorf("invalid value for field %q: %s", k, err.Error())
+		}
+		r[field] = value
+	}
+
+	*m = r
+	return nil
+}
+
+func unmarshalJSONField(data json.RawMessage) (string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+
+	var obj struct {
+		Hex *string `json:"hex"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return "", err
+	}
+	if obj.Hex == nil {
+		return "", fmt.Errorf("expecting string or {\"hex\":...}")
+	}
+	decoded, err := hex.DecodeString(*obj.Hex)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+func isPrintable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !(0x20 <= s[i] && s[i] <= 0x7E) {
+			return false
+		}
+	}
+	return true
+}
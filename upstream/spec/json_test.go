@@ -0,0 +1,112 @@
+package spec_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+var jsonGolden = []struct {
+	file string
+	msg  spec.Msg
+}{
+	{
+		file: "msg_financial.json",
+		msg: spec.Msg{
+			0:  "0200",
+			2:  "4111111111111111",
+			3:  "000000",
+			4:  "000000010000",
+			11: "000001",
+			41: "TERM0001",
+			52: "\x04\x12\xA4\xEE\xEE\xEE\xEE\xEE",
+		},
+	},
+	{
+		file: "msg_network.json",
+		msg: spec.Msg{
+			0:  "0800",
+			7:  "1019103520",
+			11: "000002",
+			70: "301",
+		},
+	},
+	{
+		file: "msg_no_mti.json",
+		msg: spec.Msg{
+			3:  "310000",
+			48: `quote " and \ backslash`,
+			62: "\x00\xFF",
+		},
+	},
+}
+
+func readGolden(t *testing.T, name string) []byte {
+	golden, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("cannot read golden file: %s", err.Error())
+	}
+	return bytes.TrimSpace(golden)
+}
+
+func TestMarshalJSONGolden(t *testing.T) {
+	for _, tc := range jsonGolden {
+		output, err := json.Marshal(tc.msg)
+		if err != nil {
+			t.Fatalf("%s: invalid err: %s", tc.file, err.Error())
+		}
+		if golden := readGolden(t, tc.file); !bytes.Equal(golden, output) {
+			t.Fatalf("%s: invalid encoded:\nwant: %s\ngot:  %s", tc.file, golden, output)
+		}
+	}
+}
+
+func TestUnmarshalJSONGolden(t *testing.T) {
+	for _, tc := range jsonGolden {
+		var output spec.Msg
+		if err := json.Unmarshal(readGolden(t, tc.file), &output); err != nil {
+			t.Fatalf("%s: invalid err: %s", tc.file, err.Error())
+		}
+		if !reflect.DeepEqual(tc.msg, output) {
+			t.Fatalf("%s: invalid decoded: %#v", tc.file, output)
+		}
+	}
+}
+
+func TestMarshalJSONStable(t *testing.T) {
+	msg := spec.Msg{}
+	for i := 1; i <= 128; i++ {
+		msg[i] = "x"
+	}
+	first, _ := json.Marshal(msg)
+	for i := 0; i < 20; i++ {
+		output, _ := json.Marshal(msg.Clone())
+		if !bytes.Equal(first, output) {
+			t.Fatalf("invalid encoded: not stable")
+		}
+	}
+}
+
+func TestUnmarshalJSONInvalid(t *testing.T) {
+	inputs := []string{
+		`{"0":"0200"}`,
+		`{"-1":"x"}`,
+		`{"02":"x"}`,
+		`{"abc":"x"}`,
+		`{"2":12}`,
+		`{"2":{"hex":"zz"}}`,
+		`{"2":{"other":"00"}}`,
+		`["0200"]`,
+	}
+	for _, input := range inputs {
+		var output spec.Msg
+		if err := json.Unmarshal([]byte(input), &output); err == nil {
+			t.Fatalf("%s: invalid err", input)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package spec
+
+import "sync"
+
+// WorkingKeys is session keys exchanged with the host, e.g. clear keys or key names in an HSM.
+type WorkingKeys struct {
+	PIN  []byte
+	MAC  []byte
+	Data []byte
+}
+
+// KeyAware is optionally implemented by Spec that need the working keys of the connection, e.g. for PIN or MAC.
+type KeyAware interface {
+	// SetWorkingKeys is called with the keys of the connection before its message is encoded or decoded,
+	// and with nil when the connection is closed
+	SetWorkingKeys(keys *WorkingKeys)
+}
+
+// KeyHolder is thread-safe holder of WorkingKeys, it implements KeyAware,
+// so it can be embedded in Spec implementation, or used with Decorate via Hooks.
+type KeyHolder struct {
+	lock sync.RWMutex
+	keys *WorkingKeys
+}
+
+// SetWorkingKeys .
+func (h *KeyHolder) SetWorkingKeys(keys *WorkingKeys) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.keys = keys
+}
+
+// WorkingKeys return the current working keys, or nil if no key exchange done on the current connection.
+func (h *KeyHolder) WorkingKeys() *WorkingKeys {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.keys
+}
+
+// Hooks return Hooks that keep h updated, to be used with Decorate.
+func (h *KeyHolder) Hooks() Hooks {
+	return Hooks{OnWorkingKeys: h.SetWorkingKeys}
+}
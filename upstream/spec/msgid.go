@@ -0,0 +1,121 @@
+package spec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// IDFunc compute the correlation id of a message, it can be used to implement Spec.MsgID.
+type IDFunc func(msg Msg) (id string)
+
+// IDFields return IDFunc that join value of fields.
+// Absent field and empty field produce different id.
+func IDFields(fields ...int) IDFunc {
+	fields = append([]int(nil), fields...)
+	return func(msg Msg) string {
+		var b strings.Builder
+		for i, f := range fields {
+			if i > 0 {
+				b.WriteByte('|')
+			}
+			if v, ok := msg[f]; ok {
+				b.WriteString(strconv.Itoa(len(v)))
+				b.WriteByte(':')
+				b.WriteString(v)
+			} else {
+				b.WriteByte('-')
+			}
+		}
+		return b.String()
+	}
+}
+
+// IDSTAN use only STAN (11) as id.
+var IDSTAN = IDFields(11)
+
+// IDSTANTerminal use STAN (11), terminal id (41), acquirer id (32) and transmission date time (7) as id,
+// so STAN doesn't collide across terminals and across day boundaries.
+var IDSTANTerminal = IDFields(11, 41, 32, 7)
+
+// MTIClass return the part of MTI that is shared by the request and its response,
+// e.g. both 0200 and 0210 return "020", both 0420 and 0430 return "042".
+// The message origin (last digit) is ignored, so repeat (0201) also match the response (0210).
+func MTIClass(mti string) string {
+	if len(mti) != 4 {
+		return mti
+	}
+	function := mti[2]
+	if '0' <= function && function <= '9' {
+		function = '0' + (function-'0')&^1
+	}
+	return mti[:2] + string(function)
+}
+
+// WithMTIClass return IDFunc that prefix id of inner with MTIClass of the message,
+// so 0200 matches 0210 and 0400 matches 0410, but 0200 doesn't match 0410.
+func WithMTIClass(inner IDFunc) IDFunc {
+	return func(msg Msg) string {
+		return MTIClass(msg[FieldMTI]) + "/" + inner(msg)
+	}
+}
+
+// ErrIDCollision .
+type ErrIDCollision struct {
+	ID string
+}
+
+func (e *ErrIDCollision) Error() string {
+	return fmt.Sprintf("id collision: %q already in flight", e.ID)
+}
+
+// IDTracker track id of in-flight requests, to detect two in-flight requests that produce the same id.
+type IDTracker struct {
+	fn IDFunc
+
+	lock     sync.Mutex
+	inFlight map[string]struct{}
+}
+
+// NewIDTracker .
+func NewIDTracker(fn IDFunc) *IDTracker {
+	return &IDTracker{
+		fn:       fn,
+		inFlight: make(map[string]struct{}),
+	}
+}
+
+// Acquire mark the id of msg as in flight, it returns *ErrIDCollision if the id already in flight.
+// Release must be called with the returned id when the request is done.
+func (t *IDTracker) Acquire(msg Msg) (string, error) {
+	id := t.fn(msg)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.inFlight[id]; ok {
+		return "", &ErrIDCollision{ID: id}
+	}
+	t.inFlight[id] = struct{}{}
+	return id, nil
+}
+
+// Release .
+func (t *IDTracker) Release(id string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.inFlight, id)
+}
+
+// InFlight report whether msg would collide with in-flight request.
+func (t *IDTracker) InFlight(msg Msg) bool {
+	id := t.fn(msg)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	_, ok := t.inFlight[id]
+	return ok
+}
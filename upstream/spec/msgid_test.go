@@ -0,0 +1,79 @@
+package spec_test
+
+import (
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+func TestIDSTANTerminal(t *testing.T) {
+	a := spec.Msg{0: "0200", 7: "1019103520", 11: "000001", 32: "008", 41: "TERM0001"}
+	b := spec.Msg{0: "0200", 7: "1019103520", 11: "000001", 32: "008", 41: "TERM0002"}
+	c := spec.Msg{0: "0200", 7: "1020103520", 11: "000001", 32: "008", 41: "TERM0001"}
+
+	if spec.IDSTAN(a) != spec.IDSTAN(b) {
+		t.Fatalf("invalid id")
+	}
+	if spec.IDSTANTerminal(a) == spec.IDSTANTerminal(b) || spec.IDSTANTerminal(a) == spec.IDSTANTerminal(c) {
+		t.Fatalf("invalid id")
+	}
+
+	// absent and empty fields must not collide
+	if spec.IDFields(11, 41)(spec.Msg{11: "1"}) == spec.IDFields(11, 41)(spec.Msg{11: "1", 41: ""}) {
+		t.Fatalf("invalid id")
+	}
+	// separator inside value must not collide
+	if spec.IDFields(11, 41)(spec.Msg{11: "1|1:1"}) == spec.IDFields(11, 41)(spec.Msg{11: "1", 41: "1"}) {
+		t.Fatalf("invalid id")
+	}
+}
+
+func TestWithMTIClass(t *testing.T) {
+	id := spec.WithMTIClass(spec.IDSTAN)
+	req := spec.Msg{0: "0200", 11: "000001"}
+	for mti, match := range map[string]bool{
+		"0210": true,
+		"0201": true,
+		"0400": false,
+		"0410": false,
+		"0230": false,
+	} {
+		if (id(req) == id(spec.Msg{0: mti, 11: "000001"})) != match {
+			t.Fatalf("%s: invalid id", mti)
+		}
+	}
+
+	if id(spec.Msg{0: "0400", 11: "1"}) != id(spec.Msg{0: "0410", 11: "1"}) {
+		t.Fatalf("invalid id")
+	}
+}
+
+func TestIDTracker(t *testing.T) {
+	tracker := spec.NewIDTracker(spec.IDSTANTerminal)
+	a := spec.Msg{11: "000001", 41: "TERM0001"}
+	b := spec.Msg{11: "000001", 41: "TERM0002"}
+
+	idA, err := tracker.Acquire(a)
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if _, err := tracker.Acquire(b); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if _, err := tracker.Acquire(a.Clone()); err == nil {
+		t.Fatalf("invalid err")
+	} else if _, ok := err.(*spec.ErrIDCollision); !ok {
+		t.Fatalf("invalid err: %#v", err)
+	}
+	if !tracker.InFlight(a) {
+		t.Fatalf("invalid in flight")
+	}
+
+	tracker.Release(idA)
+	if tracker.InFlight(a) {
+		t.Fatalf("invalid in flight")
+	}
+	if _, err := tracker.Acquire(a); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+}
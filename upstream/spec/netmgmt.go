@@ -0,0 +1,32 @@
+package spec
+
+import "time"
+
+// Network management information codes (field 70).
+const (
+	NMISignOn    = "001"
+	NMISignOff   = "002"
+	NMIKeyChange = "161"
+	NMICutover   = "201"
+	NMIEcho      = "301"
+)
+
+// NewNetworkMgmtMsg create 0800 message with network management information code (field 70)
+// and transmission date and time (field 7, MMDDhhmmss in UTC).
+// STAN (field 11) is not set, Upstream fill it when the message is sent by NetworkMgmtFunc.
+func NewNetworkMgmtMsg(code string) Msg {
+	return Msg{
+		FieldMTI: "0800",
+		7:        time.Now().UTC().Format("0102150405"),
+		70:       code,
+	}
+}
+
+// IsNetworkMgmt report whether msg is network management request (08x0) with network management information code.
+func IsNetworkMgmt(msg Msg, code string) bool {
+	mti := msg[FieldMTI]
+	if len(mti) != 4 || mti[1] != '8' || (mti[2]-'0')%2 != 0 {
+		return false
+	}
+	return msg[70] == code
+}
@@ -0,0 +1,22 @@
+package spec_test
+
+import (
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+func TestNewNetworkMgmtMsg(t *testing.T) {
+	a := spec.NewNetworkMgmtMsg(spec.NMISignOn)
+
+	if a[0] != "0800" || a[70] != spec.NMISignOn || len(a[7]) != 10 {
+		t.Fatalf("invalid network management message: %v", a)
+	}
+	if _, ok := a[11]; ok {
+		t.Fatalf("STAN must be filled by Upstream")
+	}
+
+	if !spec.IsNetworkMgmt(a, spec.NMISignOn) || spec.IsNetworkMgmt(a, spec.NMIEcho) {
+		t.Fatalf("invalid IsNetworkMgmt")
+	}
+}
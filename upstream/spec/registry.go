@@ -1,26 +1,113 @@
 package spec
 
-import "sync"
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory create a Spec from configuration, e.g. terminal id, acquirer id, keys.
+type Factory func(cfg map[string]string) (Spec, error)
+
+// ErrDuplicateName .
+type ErrDuplicateName struct {
+	Name string
+}
+
+func (e *ErrDuplicateName) Error() string {
+	return fmt.Sprintf("spec %q already registered", e.Name)
+}
+
+// ErrNotFound .
+type ErrNotFound struct {
+	Name string
+}
+
+func (e *ErrNotFound) Error() string {
+	return fmt.Sprintf("spec %q is not registered", e.Name)
+}
 
 var registry = struct {
 	lock       sync.Mutex
-	registered map[string]Spec
+	registered map[string]Factory
 }{
-	registered: map[string]Spec{},
+	registered: map[string]Factory{},
+}
+
+// Register register pre-built spec under name.
+// It returns *ErrDuplicateName if name already registered.
+func Register(name string, spec Spec) error {
+	if spec == nil {
+		return fmt.Errorf("spec %q is nil", name)
+	}
+	return RegisterFactory(name, func(map[string]string) (Spec, error) { return spec, nil })
 }
 
-// Register .
-func Register(name string, spec Spec) {
+// RegisterFactory register factory under name, the factory is called on every New.
+// It returns *ErrDuplicateName if name already registered.
+func RegisterFactory(name string, factory Factory) error {
+	if factory == nil {
+		return fmt.Errorf("factory for spec %q is nil", name)
+	}
+
 	registry.lock.Lock()
 	defer registry.lock.Unlock()
 
-	registry.registered[name] = spec
+	if _, ok := registry.registered[name]; ok {
+		return &ErrDuplicateName{Name: name}
+	}
+	registry.registered[name] = factory
+	return nil
+}
+
+// New create spec registered under name with cfg.
+// It returns *ErrNotFound if name is not registered.
+func New(name string, cfg map[string]string) (Spec, error) {
+	registry.lock.Lock()
+	factory, ok := registry.registered[name]
+	registry.lock.Unlock()
+
+	if !ok {
+		return nil, &ErrNotFound{Name: name}
+	}
+
+	spec, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create spec %q: %s", name, err.Error())
+	}
+	if spec == nil {
+		return nil, fmt.Errorf("cannot create spec %q: factory returning nil", name)
+	}
+	return spec, nil
 }
 
-// Get .
+// Get return spec registered under name created with nil configuration, or nil if it is not registered or the creation failed.
 func Get(name string) Spec {
+	spec, err := New(name, nil)
+	if err != nil {
+		return nil
+	}
+	return spec
+}
+
+// MustGet is like Get, but panic instead of returning nil.
+func MustGet(name string) Spec {
+	spec, err := New(name, nil)
+	if err != nil {
+		panic(err.Error())
+	}
+	return spec
+}
+
+// List return sorted names of registered spec.
+func List() []string {
 	registry.lock.Lock()
 	defer registry.lock.Unlock()
 
-	return registry.registered[name]
+	names := make([]string, 0, len(registry.registered))
+	for name := range registry.registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
@@ -0,0 +1,114 @@
+package spec_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+type dummySpec struct {
+	terminal string
+}
+
+func (dummySpec) OnNewConn(ctx context.Context, conn net.Conn, readed []byte) ([]byte, error) {
+	return readed, nil
+}
+func (dummySpec) MsgEncode(decoded spec.Msg) ([]byte, error) { return nil, nil }
+func (dummySpec) MsgDecode(encoded []byte) (int, spec.Msg, int, error) {
+	return 0, nil, 0, fmt.Errorf("not implemented")
+}
+func (dummySpec) MsgID(msg spec.Msg) string             { return msg[11] }
+func (dummySpec) AutoResp(req spec.Msg) spec.Msg        { return nil }
+func (dummySpec) GetPingMsg() (spec.Msg, time.Duration) { return nil, 0 }
+
+var registryRun int32
+
+// uniqueName return name that is not registered by previous run of the test, e.g. with -count=2
+func uniqueName(name string) string {
+	return fmt.Sprintf("%s-%d", name, atomic.AddInt32(&registryRun, 1))
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	name := uniqueName("test-registry-duplicate")
+	if err := spec.Register(name, dummySpec{}); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	err := spec.Register(name, dummySpec{})
+	if _, ok := err.(*spec.ErrDuplicateName); !ok {
+		t.Fatalf("invalid err: %#v", err)
+	}
+	if spec.Get(name) == nil {
+		t.Fatalf("invalid spec")
+	}
+}
+
+func TestRegistryFactory(t *testing.T) {
+	name := uniqueName("test-registry-factory")
+	err := spec.RegisterFactory(name, func(cfg map[string]string) (spec.Spec, error) {
+		if cfg["terminal"] == "" {
+			return nil, fmt.Errorf("terminal is required")
+		}
+		return dummySpec{terminal: cfg["terminal"]}, nil
+	})
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+
+	s, err := spec.New(name, map[string]string{"terminal": "TERM0001"})
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if s.(dummySpec).terminal != "TERM0001" {
+		t.Fatalf("invalid spec")
+	}
+
+	if _, err := spec.New(name, nil); err == nil {
+		t.Fatalf("invalid err")
+	}
+	if spec.Get(name) != nil {
+		t.Fatalf("invalid spec")
+	}
+}
+
+func TestRegistryNotFound(t *testing.T) {
+	if _, err := spec.New("test-registry-not-found", nil); err == nil {
+		t.Fatalf("invalid err")
+	} else if _, ok := err.(*spec.ErrNotFound); !ok {
+		t.Fatalf("invalid err: %#v", err)
+	}
+	if spec.Get("test-registry-not-found") != nil {
+		t.Fatalf("invalid spec")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("MustGet must panic")
+		}
+	}()
+	spec.MustGet("test-registry-not-found")
+}
+
+func TestRegistryList(t *testing.T) {
+	prefix := uniqueName("test-registry-list")
+	spec.Register(prefix+"-b", dummySpec{})
+	spec.Register(prefix+"-a", dummySpec{})
+
+	names := spec.List()
+	indexA, indexB := -1, -1
+	for i, name := range names {
+		switch name {
+		case prefix + "-a":
+			indexA = i
+		case prefix + "-b":
+			indexB = i
+		}
+	}
+	if indexA < 0 || indexB < 0 || indexA > indexB {
+		t.Fatalf("invalid list: %v", names)
+	}
+}
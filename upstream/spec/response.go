@@ -0,0 +1,77 @@
+package spec
+
+import "fmt"
+
+// NetworkMgmtEchoFields is the default list of fields that a network management response must echo from its request.
+var NetworkMgmtEchoFields = []int{7, 11, 70}
+
+// ResponseMTI return the response MTI of request MTI, e.g. 0200 => 0210, 0420 => 0430, 0800 => 0810.
+// The repeat flag of the message origin is cleared, e.g. 0201 => 0210, 0421 => 0430.
+func ResponseMTI(mti string) (string, error) {
+	if len(mti) != 4 || !validDecimal(mti) {
+		return "", fmt.Errorf("invalid MTI: %q", mti)
+	}
+	function := mti[2] - '0'
+	if function%2 != 0 {
+		return "", fmt.Errorf("MTI %s is not a request", mti)
+	}
+	origin := (mti[3] - '0') &^ 1
+	return mti[:2] + string('0'+function+1) + string('0'+origin), nil
+}
+
+// ResponseBuilder build response skeleton from a request.
+type ResponseBuilder struct {
+	// EchoFields is the list of fields copied from request, when nil, spec.EchoFields is used
+	EchoFields []int
+}
+
+// Build return a new response for req, with the MTI flipped, echo fields copied,
+// respCode written to field 39, and authID written to field 38 if not empty.
+func (b ResponseBuilder) Build(req Msg, respCode string, authID string) (Msg, error) {
+	mti, err := ResponseMTI(req[FieldMTI])
+	if err != nil {
+		return nil, err
+	}
+
+	echoFields := b.EchoFields
+	if echoFields == nil {
+		echoFields = EchoFields
+	}
+
+	resp := Msg{FieldMTI: mti}
+	for _, f := range echoFields {
+		if v, ok := req[f]; ok {
+			resp[f] = v
+		}
+	}
+	if authID != "" {
+		resp[38] = authID
+	}
+	resp[39] = respCode
+
+	return resp, nil
+}
+
+// NewResponse is shorthand for ResponseBuilder{}.Build(req, respCode, authID).
+func NewResponse(req Msg, respCode string, authID string) (Msg, error) {
+	return ResponseBuilder{}.Build(req, respCode, authID)
+}
+
+// NewAdviceResponse build response for advice message (e.g. 0220 => 0230, 0420 => 0430, 0421 => 0430).
+func NewAdviceResponse(req Msg, respCode string) (Msg, error) {
+	mti := req[FieldMTI]
+	if len(mti) != 4 || mti[2] != '2' {
+		return nil, fmt.Errorf("MTI %q is not an advice", mti)
+	}
+	return ResponseBuilder{}.Build(req, respCode, "")
+}
+
+// NewNetworkMgmtResponse build response for network management message (0800 => 0810),
+// echoing NetworkMgmtEchoFields.
+func NewNetworkMgmtResponse(req Msg, respCode string) (Msg, error) {
+	mti := req[FieldMTI]
+	if len(mti) != 4 || mti[1] != '8' {
+		return nil, fmt.Errorf("MTI %q is not a network management message", mti)
+	}
+	return ResponseBuilder{EchoFields: NetworkMgmtEchoFields}.Build(req, respCode, "")
+}
@@ -0,0 +1,91 @@
+package spec_test
+
+import (
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func TestResponseMTI(t *testing.T) {
+	valid := map[string]string{
+		"0100": "0110",
+		"0200": "0210",
+		"0220": "0230",
+		"0201": "0210",
+		"0400": "0410",
+		"0421": "0430",
+		"0423": "0432",
+		"0800": "0810",
+	}
+	for req, resp := range valid {
+		output, err := spec.ResponseMTI(req)
+		if err != nil {
+			t.Fatalf("%s: invalid err: %s", req, err.Error())
+		}
+		if output != resp {
+			t.Fatalf("%s: invalid response MTI: %s", req, output)
+		}
+	}
+
+	for _, req := range []string{"0210", "020", "02A0", ""} {
+		if _, err := spec.ResponseMTI(req); err == nil {
+			t.Fatalf("%s: invalid err", req)
+		}
+	}
+}
+
+func TestNewResponse(t *testing.T) {
+	req := spec.Msg{0: "0200", 2: "4111111111111111", 3: "000000", 4: "000000010000", 11: "000001", 41: "TERM0001", 52: "secret"}
+	resp, err := spec.NewResponse(req, "00", "ABC123")
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{
+		0:  "0210",
+		2:  "4111111111111111",
+		3:  "000000",
+		4:  "000000010000",
+		11: "000001",
+		38: "ABC123",
+		39: "00",
+		41: "TERM0001",
+	}, resp)
+
+	resp, err = spec.ResponseBuilder{EchoFields: []int{11}}.Build(req, "05", "")
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{0: "0210", 11: "000001", 39: "05"}, resp)
+}
+
+func TestNewAdviceResponse(t *testing.T) {
+	resp, err := spec.NewAdviceResponse(spec.Msg{0: "0420", 11: "000001"}, "00")
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{0: "0430", 11: "000001", 39: "00"}, resp)
+
+	resp, err = spec.NewAdviceResponse(spec.Msg{0: "0421", 11: "000001"}, "00")
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if resp[0] != "0430" {
+		t.Fatalf("invalid repeat advice response MTI: %s", resp[0])
+	}
+	if _, err := spec.NewAdviceResponse(spec.Msg{0: "0400"}, "00"); err == nil {
+		t.Fatalf("invalid err")
+	}
+}
+
+func TestNewNetworkMgmtResponse(t *testing.T) {
+	resp, err := spec.NewNetworkMgmtResponse(spec.Msg{0: "0800", 7: "1019103520", 11: "000001", 41: "TERM0001", 70: "301"}, "00")
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{0: "0810", 7: "1019103520", 11: "000001", 39: "00", 70: "301"}, resp)
+
+	if _, err := spec.NewNetworkMgmtResponse(spec.Msg{0: "0200"}, "00"); err == nil {
+		t.Fatalf("invalid err")
+	}
+}
@@ -0,0 +1,211 @@
+package spec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Presence of a field in a message.
+type Presence int
+
+// Presence values
+const (
+	PresenceOptional Presence = iota
+	PresenceMandatory
+	PresenceConditional
+	PresenceForbidden
+)
+
+func (p Presence) String() string {
+	switch p {
+	case PresenceOptional:
+		return "optional"
+	case PresenceMandatory:
+		return "mandatory"
+	case PresenceConditional:
+		return "conditional"
+	case PresenceForbidden:
+		return "forbidden"
+	default:
+		return fmt.Sprintf("Presence(%d)", int(p))
+	}
+}
+
+// FieldRule .
+type FieldRule struct {
+	Field    int
+	Presence Presence
+
+	// Condition is only used when Presence is PresenceConditional,
+	// the field is mandatory when it returns true, and optional otherwise.
+	Condition func(msg Msg) bool
+}
+
+// Mandatory .
+func Mandatory(fields ...int) []FieldRule {
+	return fieldRules(PresenceMandatory, fields)
+}
+
+// Optional .
+func Optional(fields ...int) []FieldRule {
+	return fieldRules(PresenceOptional, fields)
+}
+
+// Forbidden .
+func Forbidden(fields ...int) []FieldRule {
+	return fieldRules(PresenceForbidden, fields)
+}
+
+// Conditional make field mandatory when cond returns true.
+func Conditional(field int, cond func(msg Msg) bool) []FieldRule {
+	return []FieldRule{{Field: field, Presence: PresenceConditional, Condition: cond}}
+}
+
+func fieldRules(presence Presence, fields []int) []FieldRule {
+	ret := make([]FieldRule, len(fields))
+	for i, f := range fields {
+		ret[i] = FieldRule{Field: f, Presence: presence}
+	}
+	return ret
+}
+
+// Violation .
+type Violation struct {
+	Field    int
+	Presence Presence
+}
+
+func (v Violation) String() string {
+	if v.Presence == PresenceForbidden {
+		return fmt.Sprintf("field %d is forbidden", v.Field)
+	}
+	return fmt.Sprintf("field %d is %s but missing", v.Field, v.Presence)
+}
+
+// ErrValidation .
+type ErrValidation struct {
+	MTI        string
+	Violations []Violation
+}
+
+func (e *ErrValidation) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = v.String()
+	}
+	return fmt.Sprintf("invalid %s message: %s", e.MTI, strings.Join(parts, ", "))
+}
+
+// Rules declare field presence per MTI, and optionally per processing code (field 3).
+// Fields that are not declared are optional, and MTI without declared rules is always valid.
+type Rules struct {
+	lock     sync.RWMutex
+	byMTI    map[string][]FieldRule
+	byPCode  map[string]map[string][]FieldRule
+	pCodeLen map[string][]int
+}
+
+// NewRules .
+func NewRules() *Rules {
+	return &Rules{
+		byMTI:    make(map[string][]FieldRule),
+		byPCode:  make(map[string]map[string][]FieldRule),
+		pCodeLen: make(map[string][]int),
+	}
+}
+
+// Add declare rules for mti.
+func (r *Rules) Add(mti string, rules ...[]FieldRule) *Rules {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, x := range rules {
+		r.byMTI[mti] = append(r.byMTI[mti], x...)
+	}
+	return r
+}
+
+// AddForProcessingCode declare rules for mti when the processing code (field 3) starts with pCode,
+// e.g. "00" for purchase, "31" for balance inquiry.
+// These rules override rules declared by Add, and rules of longer pCode override rules of shorter one.
+func (r *Rules) AddForProcessingCode(mti string, pCode string, rules ...[]FieldRule) *Rules {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	m := r.byPCode[mti]
+	if m == nil {
+		m = make(map[string][]FieldRule)
+		r.byPCode[mti] = m
+	}
+	if _, ok := m[pCode]; !ok {
+		r.pCodeLen[mti] = append(r.pCodeLen[mti], len(pCode))
+		sort.Ints(r.pCodeLen[mti])
+	}
+	for _, x := range rules {
+		m[pCode] = append(m[pCode], x...)
+	}
+	return r
+}
+
+// Validate check msg against the declared rules, the returned error is *ErrValidation.
+func (r *Rules) Validate(msg Msg) error {
+	mti := msg[FieldMTI]
+
+	effective := make(map[int]FieldRule)
+
+	r.lock.RLock()
+	for _, rule := range r.byMTI[mti] {
+		effective[rule.Field] = rule
+	}
+	pCode := msg[3]
+	seenLen := -1
+	for _, l := range r.pCodeLen[mti] {
+		if l == seenLen || l > len(pCode) {
+			continue
+		}
+		seenLen = l
+		for _, rule := range r.byPCode[mti][pCode[:l]] {
+			effective[rule.Field] = rule
+		}
+	}
+	r.lock.RUnlock()
+
+	var violations []Violation
+	for f, rule := range effective {
+		_, present := msg[f]
+		switch rule.Presence {
+		case PresenceMandatory:
+			if !present {
+				violations = append(violations, Violation{Field: f, Presence: rule.Presence})
+			}
+		case PresenceConditional:
+			if !present && rule.Condition != nil && rule.Condition(msg) {
+				violations = append(violations, Violation{Field: f, Presence: rule.Presence})
+			}
+		case PresenceForbidden:
+			if present {
+				violations = append(violations, Violation{Field: f, Presence: rule.Presence})
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		sort.Slice(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+		return &ErrValidation{MTI: mti, Violations: violations}
+	}
+	return nil
+}
+
+// Hooks return Hooks that validate message before encode, to be used with Decorate.
+func (r *Rules) Hooks() Hooks {
+	return Hooks{
+		BeforeEncode: func(msg Msg) (Msg, error) {
+			if err := r.Validate(msg); err != nil {
+				return nil, err
+			}
+			return msg, nil
+		},
+	}
+}
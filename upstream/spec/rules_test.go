@@ -0,0 +1,90 @@
+package spec_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+func newTestRules() *spec.Rules {
+	noTrack2 := func(msg spec.Msg) bool {
+		_, ok := msg[35]
+		return !ok
+	}
+	return spec.NewRules().
+		Add("0200",
+			spec.Mandatory(3, 4, 7, 11, 41),
+			spec.Conditional(2, noTrack2),
+			spec.Conditional(14, noTrack2),
+			spec.Forbidden(39),
+		).
+		AddForProcessingCode("0200", "31", spec.Optional(4)).
+		AddForProcessingCode("0200", "3100", spec.Mandatory(102))
+}
+
+func violatedFields(t *testing.T, err error) []int {
+	t.Helper()
+	var verr *spec.ErrValidation
+	if !errors.As(err, &verr) {
+		t.Fatalf("invalid err: %#v", err)
+	}
+	var fields []int
+	for _, v := range verr.Violations {
+		fields = append(fields, v.Field)
+	}
+	return fields
+}
+
+func TestRulesValid(t *testing.T) {
+	rules := newTestRules()
+	msg := spec.Msg{0: "0200", 2: "4111111111111111", 3: "000000", 4: "000000010000", 7: "1019103520", 11: "000001", 14: "2812", 41: "TERM0001"}
+	if err := rules.Validate(msg); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+
+	// conditional fields are not needed when track 2 is present
+	msg = spec.Msg{0: "0200", 3: "000000", 4: "000000010000", 7: "1019103520", 11: "000001", 35: "4111111111111111=2812", 41: "TERM0001"}
+	if err := rules.Validate(msg); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+
+	// unknown MTI has no rules
+	if err := rules.Validate(spec.Msg{0: "0100"}); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+}
+
+func TestRulesViolation(t *testing.T) {
+	rules := newTestRules()
+	msg := spec.Msg{0: "0200", 3: "000000", 7: "1019103520", 11: "000001", 39: "00", 41: "TERM0001"}
+	err := rules.Validate(msg)
+	if fields := violatedFields(t, err); !reflect.DeepEqual(fields, []int{2, 4, 14, 39}) {
+		t.Fatalf("invalid violations: %v", fields)
+	}
+	if err.Error() != "invalid 0200 message: field 2 is conditional but missing, field 4 is mandatory but missing, field 14 is conditional but missing, field 39 is forbidden" {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+}
+
+func TestRulesProcessingCode(t *testing.T) {
+	rules := newTestRules()
+	msg := spec.Msg{0: "0200", 3: "310000", 7: "1019103520", 11: "000001", 35: "4111111111111111=2812", 41: "TERM0001"}
+	if fields := violatedFields(t, rules.Validate(msg)); !reflect.DeepEqual(fields, []int{102}) {
+		t.Fatalf("invalid violations: %v", fields)
+	}
+
+	msg[3] = "312000"
+	if err := rules.Validate(msg); err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+}
+
+func TestRulesHooks(t *testing.T) {
+	s := spec.Decorate(lineSpec{}, newTestRules().Hooks())
+	_, err := s.MsgEncode(spec.Msg{0: "0200", 11: "000001"})
+	if fields := violatedFields(t, err); !reflect.DeepEqual(fields, []int{2, 3, 4, 7, 14, 41}) {
+		t.Fatalf("invalid violations: %v", fields)
+	}
+}
@@ -22,6 +22,9 @@ type Spec interface {
 	GetPingMsg() (ping Msg, duration time.Duration)
 }
 
+// FieldMTI is the Msg key that holds the message type indicator.
+const FieldMTI = 0
+
 // Msg .
 type Msg map[int]string
 
@@ -0,0 +1,37 @@
+// Package spectest provide helpers for testing code that use spec.Spec and spec.Msg.
+package spectest
+
+import (
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+// AssertMsgEqual fail the test with redacted field-by-field difference when want and got is not equal.
+func AssertMsgEqual(t testing.TB, want, got spec.Msg) {
+	t.Helper()
+	if diff := spec.FormatDiff(want, got); diff != "" {
+		t.Fatalf("message mismatch (- want, + got, ~ changed):\n%s", diff)
+	}
+}
+
+// AssertEcho fail the test when resp doesn't echo fields of req, see spec.CheckEcho.
+func AssertEcho(t testing.TB, req, resp spec.Msg, fields ...int) {
+	t.Helper()
+	if err := spec.CheckEcho(req, resp, fields...); err != nil {
+		if len(fields) == 0 {
+			fields = spec.EchoFields
+		}
+		want := spec.Msg{}
+		got := spec.Msg{}
+		for _, f := range fields {
+			if v, ok := req[f]; ok {
+				want[f] = v
+				if v, ok := resp[f]; ok {
+					got[f] = v
+				}
+			}
+		}
+		t.Fatalf("%s (- request, + response, ~ changed):\n%s", err.Error(), spec.FormatDiff(want, got))
+	}
+}
@@ -0,0 +1,196 @@
+package spectest
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+// Pair is request and its response.
+type Pair struct {
+	Request  spec.Msg
+	Response spec.Msg
+}
+
+// Config for Run.
+type Config struct {
+	// Samples is messages used for round trip, partial delivery and concatenation checks,
+	// every sample must be valid for the Spec.
+	Samples []spec.Msg
+
+	// Pairs is request and response that must produce the same MsgID.
+	Pairs []Pair
+
+	// IgnoreFields is fields that is not compared in round trip check,
+	// e.g. bitmap or MAC that is filled by the Spec itself.
+	IgnoreFields []int
+}
+
+// Run check that s honor the implicit contracts of spec.Spec:
+//
+//   - every sample survive MsgEncode => MsgDecode round trip
+//   - MsgDecode on every proper prefix of encoded sample report needMore > 0 without error,
+//     and needMore never point past the end of the message
+//   - the sample can be read by spec.ReadOneMessage when delivered one byte at a time
+//   - concatenated samples in one buffer are decoded one by one with correct advance
+//   - MsgID of request and response in every pair is equal
+//   - ping message from GetPingMsg (if any) can be encoded and decoded
+//
+// If t is *testing.T, every check is run as subtest, otherwise the checks are run in order until the first failure.
+func Run(t testing.TB, s spec.Spec, cfg Config) {
+	t.Helper()
+
+	run := func(name string, fn func(t testing.TB)) {
+		if tt, ok := t.(*testing.T); ok {
+			tt.Run(name, func(t *testing.T) { fn(t) })
+			return
+		}
+		fn(t)
+	}
+
+	run("RoundTrip", func(t testing.TB) {
+		for i, sample := range cfg.Samples {
+			encoded := mustEncode(t, s, i, sample)
+			advance, decoded, needMore, err := s.MsgDecode(encoded)
+			if err != nil {
+				t.Fatalf("sample %d: MsgDecode error: %s", i, err.Error())
+			}
+			if needMore > 0 {
+				t.Fatalf("sample %d: MsgDecode need %d more bytes on complete message", i, needMore)
+			}
+			if advance != len(encoded) {
+				t.Fatalf("sample %d: MsgDecode advance %d, expected %d", i, advance, len(encoded))
+			}
+			assertSample(t, i, cfg, sample, decoded)
+		}
+	})
+
+	run("PartialDelivery", func(t testing.TB) {
+		for i, sample := range cfg.Samples {
+			encoded := mustEncode(t, s, i, sample)
+			for n := 0; n < len(encoded); n++ {
+				advance, decoded, needMore, err := s.MsgDecode(encoded[:n])
+				if err != nil {
+					t.Fatalf("sample %d: MsgDecode error on %d of %d bytes: %s", i, n, len(encoded), err.Error())
+				}
+				if needMore <= 0 || decoded != nil || advance != 0 {
+					t.Fatalf("sample %d: MsgDecode on %d of %d bytes: expecting needMore > 0, got advance=%d needMore=%d msg=%v",
+						i, n, len(encoded), advance, needMore, decoded)
+				}
+				if n+needMore > len(encoded) {
+					t.Fatalf("sample %d: MsgDecode on %d of %d bytes: needMore %d is past the end of the message",
+						i, n, len(encoded), needMore)
+				}
+			}
+
+			decoded, raw, _, _, err := spec.ReadOneMessage(s, &oneByteReader{data: encoded}, nil, 0)
+			if err != nil {
+				t.Fatalf("sample %d: ReadOneMessage error on byte-by-byte delivery: %s", i, err.Error())
+			}
+			if !bytes.Equal(raw, encoded) {
+				t.Fatalf("sample %d: ReadOneMessage returning different raw message", i)
+			}
+			assertSample(t, i, cfg, sample, decoded)
+		}
+	})
+
+	run("Concatenated", func(t testing.TB) {
+		var all []byte
+		var lens []int
+		for i, sample := range cfg.Samples {
+			encoded := mustEncode(t, s, i, sample)
+			all = append(all, encoded...)
+			lens = append(lens, len(encoded))
+		}
+
+		for i, sample := range cfg.Samples {
+			advance, decoded, needMore, err := s.MsgDecode(all)
+			if err != nil {
+				t.Fatalf("sample %d: MsgDecode error on concatenated buffer: %s", i, err.Error())
+			}
+			if needMore > 0 || advance != lens[i] {
+				t.Fatalf("sample %d: MsgDecode on concatenated buffer: advance=%d needMore=%d, expected advance=%d",
+					i, advance, needMore, lens[i])
+			}
+			assertSample(t, i, cfg, sample, decoded)
+			all = all[advance:]
+		}
+	})
+
+	run("MsgID", func(t testing.TB) {
+		for i, p := range cfg.Pairs {
+			reqID, respID := s.MsgID(p.Request), s.MsgID(p.Response)
+			if reqID != respID {
+				t.Fatalf("pair %d: MsgID mismatch: request %q, response %q", i, reqID, respID)
+			}
+		}
+	})
+
+	run("PingMsg", func(t testing.TB) {
+		ping, duration := s.GetPingMsg()
+		if duration == 0 {
+			t.Skip("spec doesn't have ping message")
+			return
+		}
+		if ping == nil {
+			t.Fatalf("GetPingMsg returning nil message with non-zero duration")
+		}
+		encoded, err := s.MsgEncode(ping)
+		if err != nil {
+			t.Fatalf("MsgEncode ping message error: %s", err.Error())
+		}
+		advance, decoded, needMore, err := s.MsgDecode(encoded)
+		if err != nil || needMore > 0 || advance != len(encoded) || decoded == nil {
+			t.Fatalf("MsgDecode ping message: advance=%d needMore=%d err=%v", advance, needMore, err)
+		}
+		if s.MsgID(ping) != s.MsgID(decoded) {
+			t.Fatalf("MsgID of ping message changed after round trip")
+		}
+	})
+}
+
+func mustEncode(t testing.TB, s spec.Spec, i int, sample spec.Msg) []byte {
+	t.Helper()
+	encoded, err := s.MsgEncode(sample)
+	if err != nil {
+		t.Fatalf("sample %d: MsgEncode error: %s", i, err.Error())
+	}
+	if len(encoded) == 0 {
+		t.Fatalf("sample %d: MsgEncode returning empty message", i)
+	}
+	return encoded
+}
+
+func assertSample(t testing.TB, i int, cfg Config, want, got spec.Msg) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("sample %d: decoded message is nil", i)
+	}
+	want = want.Clone()
+	got = got.Clone()
+	for _, f := range cfg.IgnoreFields {
+		delete(want, f)
+		delete(got, f)
+	}
+	if diff := spec.FormatDiff(want, got); diff != "" {
+		t.Fatalf("sample %d: round trip mismatch (- want, + got, ~ changed):\n%s", i, diff)
+	}
+}
+
+type oneByteReader struct {
+	data []byte
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0] = r.data[0]
+	r.data = r.data[1:]
+	return 1, nil
+}
@@ -0,0 +1,90 @@
+package spectest_test
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+var refSamples = []spec.Msg{
+	{0: "0200", 2: "4111111111111111", 3: "000000", 4: "000000010000", 7: "1019103520", 11: "000001", 41: "TERM0001"},
+	{0: "0210", 3: "000000", 7: "1019103520", 11: "000001", 39: "00", 41: "TERM0001", 102: "1234567890"},
+	{0: "0800", 7: "1019103520", 11: "000002", 70: "301"},
+}
+
+func TestRefSpecConformance(t *testing.T) {
+	spectest.Run(t, &spectest.RefSpec{PingInterval: time.Minute}, spectest.Config{
+		Samples: refSamples,
+		Pairs: []spectest.Pair{
+			{Request: refSamples[0], Response: refSamples[1]},
+			{
+				Request:  spec.Msg{0: "0400", 7: "1019103520", 11: "000003", 41: "TERM0001"},
+				Response: spec.Msg{0: "0410", 7: "1019103520", 11: "000003", 41: "TERM0001", 39: "00"},
+			},
+		},
+	})
+}
+
+// recorder is testing.TB that record the first failure of Run
+type recorder struct {
+	testing.TB
+	failure string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+func (r *recorder) Skip(args ...interface{}) {
+	runtime.Goexit()
+}
+
+// run Run with recorder, it returns the first failure
+func run(s spec.Spec, cfg spectest.Config) string {
+	r := &recorder{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		spectest.Run(r, s, cfg)
+	}()
+	<-done
+	return r.failure
+}
+
+// nilMsgSpec is broken RefSpec that decode complete message to nil message without needMore
+type nilMsgSpec struct {
+	spectest.RefSpec
+}
+
+func (s *nilMsgSpec) MsgDecode(encoded []byte) (int, spec.Msg, int, error) {
+	advance, _, needMore, err := s.RefSpec.MsgDecode(encoded)
+	return advance, nil, needMore, err
+}
+
+func TestRunBrokenSpec(t *testing.T) {
+	cfg := spectest.Config{Samples: refSamples}
+	if failure := run(&spectest.RefSpec{}, cfg); failure != "" {
+		t.Fatalf("invalid failure of RefSpec: %s", failure)
+	}
+	if failure := run(&nilMsgSpec{}, cfg); failure != "sample 0: decoded message is nil" {
+		t.Fatalf("invalid failure: %q", failure)
+	}
+}
+
+func TestRefSpecAutoResp(t *testing.T) {
+	s := &spectest.RefSpec{}
+	spectest.AssertMsgEqual(t,
+		spec.Msg{0: "0810", 7: "1019103520", 11: "000002", 39: "00", 70: "301"},
+		s.AutoResp(refSamples[2]),
+	)
+	if s.AutoResp(refSamples[0]) != nil {
+		t.Fatalf("invalid auto resp")
+	}
+}
@@ -0,0 +1,202 @@
+package spectest
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/encoding/asciifield"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+)
+
+// RefSpec is simple reference spec.Spec, useful for testing code that need a Spec.
+//
+// Message is framed with 4 digits ascii length header, followed by 4 digits MTI,
+// primary bitmap (and secondary bitmap if any field above 64 present) written as 16 hex digits,
+// and then every present field (2-128) written as ascii LLLVAR.
+//
+// MsgID use spec.WithMTIClass(spec.IDSTANTerminal), AutoResp respond 0800 with 0810,
+// and GetPingMsg return 0800 echo (70=301) every PingInterval (zero means no ping).
+type RefSpec struct {
+	PingInterval time.Duration
+
+	pingSTAN uint32
+}
+
+var _ spec.Spec = (*RefSpec)(nil)
+
+const refMaxLen = 9999
+
+var (
+	refHeader = asciifield.FixSize(4)
+	refMTI    = asciifield.FixSize(4)
+	refBitmap = asciifield.FixSize(16)
+	refField  = asciifield.LLLVar()
+)
+
+// OnNewConn .
+func (r *RefSpec) OnNewConn(ctx context.Context, conn net.Conn, readed []byte) ([]byte, error) {
+	return readed, nil
+}
+
+// MsgEncode .
+func (r *RefSpec) MsgEncode(decoded spec.Msg) ([]byte, error) {
+	var bitmap [16]byte
+	for f := range decoded {
+		switch {
+		case f == spec.FieldMTI:
+		case 2 <= f && f <= 128:
+			bitmap[(f-1)/8] |= 0x80 >> uint((f-1)%8)
+		default:
+			return nil, fmt.Errorf("field %d is not supported", f)
+		}
+	}
+	secondary := false
+	for _, b := range bitmap[8:] {
+		if b != 0 {
+			secondary = true
+		}
+	}
+	if secondary {
+		bitmap[0] |= 0x80
+	}
+
+	mti, err := refMTI.Encode(decoded[spec.FieldMTI])
+	if err != nil {
+		return nil, fmt.Errorf("field 0: %s", err.Error())
+	}
+
+	body := mti
+	body = append(body, []byte(fmt.Sprintf("%X", bitmap[:8]))...)
+	if secondary {
+		body = append(body, []byte(fmt.Sprintf("%X", bitmap[8:]))...)
+	}
+	for f := 2; f <= 128; f++ {
+		v, ok := decoded[f]
+		if !ok {
+			continue
+		}
+		encoded, err := refField.Encode(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %s", f, err.Error())
+		}
+		body = append(body, encoded...)
+	}
+
+	if len(body) > refMaxLen {
+		return nil, fmt.Errorf("message too long")
+	}
+
+	header, _ := refHeader.Encode(fmt.Sprintf("%04d", len(body)))
+	return append(header, body...), nil
+}
+
+// MsgDecode .
+func (r *RefSpec) MsgDecode(encoded []byte) (int, spec.Msg, int, error) {
+	_, header, needMore, err := refHeader.Decode(encoded)
+	if err != nil || needMore > 0 {
+		return 0, nil, needMore, err
+	}
+	for i := 0; i < len(header); i++ {
+		if !('0' <= header[i] && header[i] <= '9') {
+			return 0, nil, 0, fmt.Errorf("invalid length header")
+		}
+	}
+	bodyLen, _ := strconv.Atoi(header)
+	if len(encoded) < 4+bodyLen {
+		return 0, nil, 4 + bodyLen - len(encoded), nil
+	}
+
+	body := encoded[4 : 4+bodyLen]
+	msg := spec.Msg{}
+
+	advance, mti, needMore, err := refMTI.Decode(body)
+	if err != nil || needMore > 0 {
+		return 0, nil, 0, fmt.Errorf("invalid MTI")
+	}
+	msg[spec.FieldMTI] = mti
+	body = body[advance:]
+
+	bitmap, body, err := refDecodeBitmap(body)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if bitmap[0]&0x80 != 0 {
+		var secondary []byte
+		secondary, body, err = refDecodeBitmap(body)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		bitmap = append(bitmap, secondary...)
+	}
+
+	for f := 2; f <= len(bitmap)*8; f++ {
+		if bitmap[(f-1)/8]&(0x80>>uint((f-1)%8)) == 0 {
+			continue
+		}
+		advance, v, needMore, err := refField.Decode(body)
+		if err != nil {
+			return 0, nil, 0, fmt.Errorf("field %d: %s", f, err.Error())
+		}
+		if needMore > 0 {
+			return 0, nil, 0, fmt.Errorf("field %d: truncated", f)
+		}
+		msg[f] = v
+		body = body[advance:]
+	}
+
+	if len(body) != 0 {
+		return 0, nil, 0, fmt.Errorf("trailing data")
+	}
+
+	return 4 + bodyLen, msg, 0, nil
+}
+
+func refDecodeBitmap(body []byte) ([]byte, []byte, error) {
+	advance, bitmapHex, needMore, err := refBitmap.Decode(body)
+	if err != nil || needMore > 0 {
+		return nil, nil, fmt.Errorf("invalid bitmap")
+	}
+	bitmap, err := hex.DecodeString(bitmapHex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid bitmap")
+	}
+	return bitmap, body[advance:], nil
+}
+
+// MsgID .
+func (r *RefSpec) MsgID(msg spec.Msg) string {
+	return refMsgID(msg)
+}
+
+var refMsgID = spec.WithMTIClass(spec.IDSTANTerminal)
+
+// AutoResp .
+func (r *RefSpec) AutoResp(req spec.Msg) spec.Msg {
+	if req[spec.FieldMTI] != "0800" {
+		return nil
+	}
+	resp, err := spec.NewNetworkMgmtResponse(req, "00")
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+// GetPingMsg .
+func (r *RefSpec) GetPingMsg() (spec.Msg, time.Duration) {
+	if r.PingInterval <= 0 {
+		return nil, 0
+	}
+	stan := atomic.AddUint32(&r.pingSTAN, 1)%999999 + 1
+	return spec.Msg{
+		spec.FieldMTI: "0800",
+		7:             time.Now().UTC().Format("0102150405"),
+		11:            fmt.Sprintf("%06d", stan),
+		70:            "301",
+	}, r.PingInterval
+}
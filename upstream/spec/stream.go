@@ -0,0 +1,181 @@
+package spec
+
+import (
+	"fmt"
+	"io"
+)
+
+const minReadSize = 256
+
+// Decoder read messages from a stream using a Spec, unprocessed data is kept in a sliding buffer.
+type Decoder struct {
+	s      Spec
+	r      io.Reader
+	limits Limits
+
+	buf   []byte
+	start int
+	end   int
+}
+
+// NewDecoder create Decoder with DefaultLimits.
+func NewDecoder(s Spec, r io.Reader) *Decoder {
+	return &Decoder{s: s, r: r, limits: DefaultLimits}
+}
+
+// WithLimits .
+func (d *Decoder) WithLimits(limits Limits) *Decoder {
+	d.limits = limits
+	return d
+}
+
+// WithBuffered prepend data that already read from the stream but not processed yet.
+func (d *Decoder) WithBuffered(data []byte) *Decoder {
+	buf := make([]byte, d.end-d.start+len(data))
+	n := copy(buf, data)
+	copy(buf[n:], d.buf[d.start:d.end])
+	d.buf, d.start, d.end = buf, 0, len(buf)
+	return d
+}
+
+// Buffered return data that already read but not processed yet.
+// The returned slice is only valid until the next call to Decode or Discard.
+func (d *Decoder) Buffered() []byte {
+	return d.buf[d.start:d.end]
+}
+
+// Discard skip the first n bytes of buffered data, it returns the number of bytes discarded.
+func (d *Decoder) Discard(n int) int {
+	if n > d.end-d.start {
+		n = d.end - d.start
+	}
+	if n < 0 {
+		n = 0
+	}
+	d.start += n
+	if d.start == d.end {
+		d.start, d.end = 0, 0
+	}
+	return n
+}
+
+// Decode read the next message, it returns the decoded message and its raw bytes.
+// On *ErrMessageTooLarge and *ErrDecode, the unprocessed data is still available via Buffered, see Resyncer.
+func (d *Decoder) Decode() (Msg, []byte, error) {
+	needMore := 0
+	emptyRead := 0
+
+	for {
+		for needMore > 0 || d.end == d.start {
+			bufferLen := d.end - d.start
+			if d.limits.MaxMessageSize > 0 && bufferLen+needMore > d.limits.MaxMessageSize {
+				return nil, nil, &ErrMessageTooLarge{Size: bufferLen + needMore, Limit: d.limits.MaxMessageSize}
+			}
+
+			if !d.makeRoom() {
+				return nil, nil, &ErrMessageTooLarge{Size: bufferLen + max(needMore, 1), Limit: d.limits.MaxBufferSize}
+			}
+
+			readLen, err := d.r.Read(d.buf[d.end:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("read error: %s", err.Error())
+			}
+
+			// guard against reader that keep returning (0, nil), see io.ErrNoProgress
+			if readLen == 0 {
+				emptyRead++
+				if emptyRead >= 100 {
+					return nil, nil, fmt.Errorf("read error: %s", io.ErrNoProgress.Error())
+				}
+				continue
+			}
+			emptyRead = 0
+
+			d.end += readLen
+			needMore -= readLen
+		}
+
+		bufferLen := d.end - d.start
+
+		advance, msg, more, err := d.s.MsgDecode(d.buf[d.start:d.end])
+		if rejected, ok := err.(*ErrRejected); ok && 0 < advance && advance <= bufferLen {
+			msgRaw := make([]byte, advance)
+			copy(msgRaw, d.buf[d.start:d.start+advance])
+			d.Discard(advance)
+			return nil, msgRaw, rejected
+		}
+		if err != nil {
+			return nil, nil, &ErrDecode{Cause: err}
+		}
+		needMore = more
+		if needMore > 0 {
+			continue
+		}
+		if msg == nil || advance == 0 {
+			panic("spec error: MsgDecode: ((msg == nil || advance == 0) && needMore <= 0 && err == nil)")
+		}
+		if advance < 0 || advance > bufferLen {
+			panic("spec error: MsgDecode: advance is past the end of the buffer")
+		}
+		if d.limits.MaxMessageSize > 0 && advance > d.limits.MaxMessageSize {
+			return nil, nil, &ErrMessageTooLarge{Size: advance, Limit: d.limits.MaxMessageSize}
+		}
+
+		msgRaw := make([]byte, advance)
+		copy(msgRaw, d.buf[d.start:d.start+advance])
+		d.Discard(advance)
+
+		return msg, msgRaw, nil
+	}
+}
+
+// makeRoom make sure there is free space at the end of the buffer, it returns false if the buffer limit is reached
+func (d *Decoder) makeRoom() bool {
+	if len(d.buf)-d.end > minReadSize {
+		return true
+	}
+
+	if d.start > 0 {
+		copy(d.buf, d.buf[d.start:d.end])
+		d.end -= d.start
+		d.start = 0
+		if len(d.buf)-d.end > minReadSize {
+			return true
+		}
+	}
+
+	newSize := max(cap(d.buf)*2, minReadSize)
+	if d.limits.MaxBufferSize > 0 && newSize > d.limits.MaxBufferSize {
+		newSize = d.limits.MaxBufferSize
+	}
+	if newSize > len(d.buf) {
+		newBuf := make([]byte, newSize)
+		copy(newBuf, d.buf[:d.end])
+		d.buf = newBuf
+	}
+
+	return len(d.buf)-d.end > 0
+}
+
+// Encoder write messages to a stream using a Spec.
+type Encoder struct {
+	s Spec
+	w io.Writer
+}
+
+// NewEncoder .
+func NewEncoder(s Spec, w io.Writer) *Encoder {
+	return &Encoder{s: s, w: w}
+}
+
+// Encode encode msg and write it to the stream, it returns the written bytes.
+func (e *Encoder) Encode(msg Msg) ([]byte, error) {
+	encoded, err := e.s.MsgEncode(msg)
+	if err != nil {
+		return nil, fmt.Errorf("encode error: %s", err.Error())
+	}
+	if _, err := e.w.Write(encoded); err != nil {
+		return nil, fmt.Errorf("write error: %s", err.Error())
+	}
+	return encoded, nil
+}
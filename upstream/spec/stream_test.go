@@ -0,0 +1,124 @@
+package spec_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func TestDecoderSequence(t *testing.T) {
+	var input []string
+	for i := 0; i < 100; i++ {
+		input = append(input, "11=000001;41=TERM0001\n")
+	}
+	d := spec.NewDecoder(lineSpec{}, &chunkReader{data: []byte(strings.Join(input, "")), sizes: []byte{3, 100, 7, 250}})
+
+	for i := 0; i < 100; i++ {
+		msg, raw, err := d.Decode()
+		if err != nil {
+			t.Fatalf("message %d: invalid err: %s", i, err.Error())
+		}
+		if string(raw) != input[i] {
+			t.Fatalf("message %d: invalid raw: %q", i, raw)
+		}
+		spectest.AssertMsgEqual(t, spec.Msg{11: "000001", 41: "TERM0001"}, msg)
+	}
+
+	if _, _, err := d.Decode(); err == nil || !strings.Contains(err.Error(), io.EOF.Error()) {
+		t.Fatalf("invalid err: %v", err)
+	}
+}
+
+func TestDecoderWithBuffered(t *testing.T) {
+	d := spec.NewDecoder(lineSpec{}, strings.NewReader("000002\n")).WithBuffered([]byte("11=000001\n11="))
+
+	msg, _, err := d.Decode()
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{11: "000001"}, msg)
+
+	msg, _, err = d.Decode()
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{11: "000002"}, msg)
+}
+
+func TestDecoderDiscard(t *testing.T) {
+	d := spec.NewDecoder(lineSpec{}, strings.NewReader("garbage\n11=000001\n"))
+
+	if _, _, err := d.Decode(); err == nil {
+		t.Fatalf("invalid err")
+	}
+	buffered := d.Buffered()
+	skip := bytes.IndexByte(buffered, '\n') + 1
+	if d.Discard(skip) != len("garbage\n") {
+		t.Fatalf("invalid discard")
+	}
+
+	msg, _, err := d.Decode()
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{11: "000001"}, msg)
+}
+
+func TestDecoderRejected(t *testing.T) {
+	errRejected := errors.New("rejected by hook")
+	s := spec.Decorate(lineSpec{}, spec.Hooks{
+		AfterDecode: func(msg spec.Msg, raw []byte) (spec.Msg, error) {
+			if msg[39] == "XX" {
+				return nil, errRejected
+			}
+			return msg, nil
+		},
+	})
+	d := spec.NewDecoder(s, strings.NewReader("11=000001\n11=000002;39=XX\n11=000003\n"))
+
+	msg, _, err := d.Decode()
+	if err != nil || msg[11] != "000001" {
+		t.Fatalf("invalid decode: %v %v", msg, err)
+	}
+
+	_, raw, err := d.Decode()
+	var rejected *spec.ErrRejected
+	if !errors.As(err, &rejected) || !errors.Is(err, errRejected) {
+		t.Fatalf("invalid err: %v", err)
+	}
+	if rejected.Msg[11] != "000002" || string(raw) != "11=000002;39=XX\n" {
+		t.Fatalf("invalid rejected message: %v %q", rejected.Msg, raw)
+	}
+
+	msg, _, err = d.Decode()
+	if err != nil || msg[11] != "000003" {
+		t.Fatalf("invalid decode after rejected message: %v %v", msg, err)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var b bytes.Buffer
+	e := spec.NewEncoder(lineSpec{}, &b)
+	for _, stan := range []string{"000001", "000002"} {
+		if _, err := e.Encode(spec.Msg{11: stan}); err != nil {
+			t.Fatalf("invalid err: %s", err.Error())
+		}
+	}
+	if _, err := e.Encode(spec.Msg{11: "\n"}); err == nil {
+		t.Fatalf("invalid err")
+	}
+
+	d := spec.NewDecoder(lineSpec{}, &b)
+	for _, stan := range []string{"000001", "000002"} {
+		msg, _, err := d.Decode()
+		if err != nil {
+			t.Fatalf("invalid err: %s", err.Error())
+		}
+		spectest.AssertMsgEqual(t, spec.Msg{11: stan}, msg)
+	}
+}
@@ -12,50 +12,100 @@ func max(a, b int) int {
 	return b
 }
 
-// ReadOneMessage .
+// Limits bound the memory used when reading messages.
+type Limits struct {
+	// MaxMessageSize is the maximum size of one encoded message, 0 means unlimited
+	MaxMessageSize int
+
+	// MaxBufferSize is the maximum size of the read buffer, 0 means unlimited
+	MaxBufferSize int
+}
+
+// DefaultLimits is used by ReadOneMessage.
+var DefaultLimits = Limits{
+	MaxMessageSize: 64 * 1024,
+	MaxBufferSize:  128 * 1024,
+}
+
+// ErrMessageTooLarge is returned when a message (or the bytes needed to decode it) exceed the limits.
+type ErrMessageTooLarge struct {
+	// Size is the known size of the message so far, the real size can be bigger
+	Size  int
+	Limit int
+}
+
+func (e *ErrMessageTooLarge) Error() string {
+	return fmt.Sprintf("message too large: at least %d bytes, limit is %d bytes", e.Size, e.Limit)
+}
+
+// ErrDecode is returned when MsgDecode failed.
+type ErrDecode struct {
+	Cause error
+}
+
+func (e *ErrDecode) Error() string {
+	return "decode error: " + e.Cause.Error()
+}
+
+// Unwrap .
+func (e *ErrDecode) Unwrap() error {
+	return e.Cause
+}
+
+// ErrRejected is returned by MsgDecode when the message is decoded but rejected, e.g. because of invalid MAC,
+// along with the advance of the message, so the message is consumed and Upstream fail only the matched request.
+type ErrRejected struct {
+	Msg   Msg
+	Cause error
+}
+
+func (e *ErrRejected) Error() string {
+	return "message rejected: " + e.Cause.Error()
+}
+
+// Unwrap .
+func (e *ErrRejected) Unwrap() error {
+	return e.Cause
+}
+
+// Resyncer is optionally implemented by Spec that can recover from framing error.
+type Resyncer interface {
+	// Resync is called with the buffered data after a framing error (*ErrDecode or *ErrMessageTooLarge),
+	// it returns the number of bytes to discard so the buffer start at the next possible message,
+	// or 0 if it cannot resynchronize, in that case the connection should be dropped.
+	Resync(buffered []byte) (skip int)
+}
+
+// ReadOneMessage is ReadOneMessageLimit with DefaultLimits.
 func ReadOneMessage(s Spec, r io.Reader, bufferIn []byte, bufferLenIn int) (msgOut Msg, msgRawOut []byte, bufferOut []byte, bufferLenOut int, err error) {
-	var advance int
-	var msg Msg
-
-	buffer := bufferIn
-	bufferLen := bufferLenIn
-	needMore := 0
-
-	for {
-		for needMore > 0 || bufferLen == 0 {
-			if len(buffer[bufferLen:]) <= 256 {
-				newBuffer := make([]byte, max(cap(buffer)*2, 256))
-				copy(newBuffer, buffer)
-				buffer = newBuffer
-			}
-
-			readLen, err := r.Read(buffer[bufferLen:])
-			if err != nil {
-				return nil, nil, buffer, bufferLen, fmt.Errorf("read error: %s", err.Error())
-			}
-
-			bufferLen += readLen
-			needMore -= readLen
-		}
+	return ReadOneMessageLimit(s, r, bufferIn, bufferLenIn, DefaultLimits)
+}
 
-		advance, msg, needMore, err = s.MsgDecode(buffer[:bufferLen])
-		if err != nil {
-			return nil, nil, buffer, bufferLen, fmt.Errorf("decode error: %s", err.Error())
-		}
-		if needMore > 0 {
-			continue
-		}
-		if msg == nil || advance == 0 {
-			panic("spec error: MsgDecode: ((msg == nil || advance == 0) && needMore <= 0 && err == nil)")
-		}
+// ReadOneMessageLimit read one message from r, bufferIn[:bufferLenIn] is data that already read but not processed yet.
+// It returns *ErrMessageTooLarge if the message exceed limits and *ErrDecode if MsgDecode failed,
+// in both cases, the returned buffer still contains the unprocessed data, see Resyncer.
+//
+// Decoder is easier to use when reading more than one message.
+func ReadOneMessageLimit(s Spec, r io.Reader, bufferIn []byte, bufferLenIn int, limits Limits) (msgOut Msg, msgRawOut []byte, bufferOut []byte, bufferLenOut int, err error) {
+	d := &Decoder{
+		s:      s,
+		r:      r,
+		limits: limits,
+		buf:    bufferIn,
+		end:    bufferLenIn,
+	}
 
-		msgRaw := make([]byte, advance)
-		copy(msgRaw, buffer[:advance])
+	msg, msgRaw, err := d.Decode()
 
-		copy(buffer, buffer[advance:bufferLen])
-		bufferLen -= advance
-		needMore = 0
+	copy(d.buf, d.buf[d.start:d.end])
+	return msg, msgRaw, d.buf, d.end - d.start, err
+}
 
-		return msg, msgRaw, buffer, bufferLen, nil
+func validDecimal(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9') {
+			return false
+		}
 	}
+	return true
 }
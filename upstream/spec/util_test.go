@@ -0,0 +1,110 @@
+package spec_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+type emptyReader struct{}
+
+func (emptyReader) Read(p []byte) (int, error) { return 0, nil }
+
+func TestReadOneMessageNoProgress(t *testing.T) {
+	_, _, _, _, err := spec.ReadOneMessage(lineSpec{}, emptyReader{}, nil, 0)
+	if err == nil || !strings.Contains(err.Error(), io.ErrNoProgress.Error()) {
+		t.Fatalf("invalid err: %v", err)
+	}
+}
+
+type overReadSpec struct{ lineSpec }
+
+func (overReadSpec) MsgDecode(encoded []byte) (int, spec.Msg, int, error) {
+	return len(encoded) + 1, spec.Msg{}, 0, nil
+}
+
+func TestReadOneMessageOverRead(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("ReadOneMessage must panic")
+		}
+	}()
+	spec.ReadOneMessage(overReadSpec{}, strings.NewReader("11=000001\n"), nil, 0)
+}
+
+func TestReadOneMessageSequence(t *testing.T) {
+	r := strings.NewReader("11=000001\n11=000002\n")
+	msg, raw, buffer, bufferLen, err := spec.ReadOneMessage(lineSpec{}, r, nil, 0)
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	if string(raw) != "11=000001\n" {
+		t.Fatalf("invalid raw: %q", raw)
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{11: "000001"}, msg)
+
+	msg, _, _, bufferLen, err = spec.ReadOneMessage(lineSpec{}, r, buffer, bufferLen)
+	if err != nil {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+	spectest.AssertMsgEqual(t, spec.Msg{11: "000002"}, msg)
+	if bufferLen != 0 {
+		t.Fatalf("invalid buffer len: %d", bufferLen)
+	}
+}
+
+type infiniteReader struct{ b byte }
+
+func (r infiniteReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.b
+	}
+	return len(p), nil
+}
+
+func TestReadOneMessageGarbage(t *testing.T) {
+	limits := spec.Limits{MaxMessageSize: 1000, MaxBufferSize: 4096}
+	_, _, buffer, bufferLen, err := spec.ReadOneMessageLimit(lineSpec{}, infiniteReader{'a'}, nil, 0, limits)
+	if _, ok := err.(*spec.ErrMessageTooLarge); !ok {
+		t.Fatalf("invalid err: %#v", err)
+	}
+	if len(buffer) > limits.MaxBufferSize || bufferLen > len(buffer) {
+		t.Fatalf("invalid buffer: len %d, bufferLen %d", len(buffer), bufferLen)
+	}
+}
+
+func TestReadOneMessageHugeLength(t *testing.T) {
+	limits := spec.Limits{MaxMessageSize: 100}
+	_, _, _, _, err := spec.ReadOneMessageLimit(&spectest.RefSpec{}, strings.NewReader("9999"+strings.Repeat("x", 200)), nil, 0, limits)
+	tooLarge, ok := err.(*spec.ErrMessageTooLarge)
+	if !ok {
+		t.Fatalf("invalid err: %#v", err)
+	}
+	if tooLarge.Size != 9999+4 || tooLarge.Limit != 100 {
+		t.Fatalf("invalid err: %s", err.Error())
+	}
+}
+
+func TestReadOneMessageBufferLimit(t *testing.T) {
+	limits := spec.Limits{MaxBufferSize: 512}
+	_, _, buffer, _, err := spec.ReadOneMessageLimit(lineSpec{}, infiniteReader{'a'}, nil, 0, limits)
+	if _, ok := err.(*spec.ErrMessageTooLarge); !ok {
+		t.Fatalf("invalid err: %#v", err)
+	}
+	if len(buffer) != 512 {
+		t.Fatalf("invalid buffer len: %d", len(buffer))
+	}
+}
+
+func TestReadOneMessageDecodeError(t *testing.T) {
+	_, _, buffer, bufferLen, err := spec.ReadOneMessage(lineSpec{}, strings.NewReader("abc\n11=000001\n"), nil, 0)
+	if _, ok := err.(*spec.ErrDecode); !ok {
+		t.Fatalf("invalid err: %#v", err)
+	}
+	if string(buffer[:bufferLen]) != "abc\n11=000001\n" {
+		t.Fatalf("unprocessed data must be kept in buffer for resync")
+	}
+}
@@ -0,0 +1,253 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/payfazz/mainutil/maintls"
+)
+
+// Target is a host to connect to, see Builder.WithTargets.
+type Target struct {
+	// Addr is host:port of the target
+	Addr string
+
+	// Proxy is the proxy used to connect to the target, nil means the proxy set by Builder.WithProxy (if any)
+	Proxy *url.URL
+
+	// ProxyCASum is the pinned CA of https proxy, see Builder.WithProxy
+	ProxyCASum string
+}
+
+// TargetPolicy is the policy to choose target for new connection, see Builder.WithTargets.
+type TargetPolicy int
+
+// TargetPolicy values
+const (
+	// PolicyFailover connect to the targets in order, moving to the next target when the current one cannot be connected,
+	// and fail back to higher priority target when it is up again, see Builder.WithFailBack.
+	PolicyFailover TargetPolicy = iota
+
+	// PolicyActiveStandby is like PolicyFailover, but without fail back,
+	// the connection stay on the standby target until it cannot be connected.
+	PolicyActiveStandby
+
+	// PolicyRoundRobin use the next target for every new connection.
+	PolicyRoundRobin
+)
+
+const defaultFailBack = 5 * time.Minute
+
+type proxy struct {
+	endpoint  *url.URL
+	tlsConfig *tls.Config
+	caSum     string
+}
+
+type target struct {
+	addr      string
+	proxy     proxy
+	tlsConfig *tls.Config
+}
+
+// init validate p and fill the default port and tls config
+func (p *proxy) init() error {
+	if p.endpoint == nil {
+		return nil
+	}
+
+	endpoint := *p.endpoint
+	p.endpoint = &endpoint
+
+	p.endpoint.Scheme = strings.ToLower(p.endpoint.Scheme)
+	switch p.endpoint.Scheme {
+	case "https":
+		tlsConfig := maintls.TLSConfig()
+		tlsConfig.ServerName = p.endpoint.Hostname()
+		if p.caSum != "" {
+			maintls.SetStaticPeerVerification(tlsConfig, true, p.caSum)
+		}
+		p.tlsConfig = tlsConfig
+		if p.endpoint.Port() == "" {
+			p.endpoint.Host = p.endpoint.Hostname() + ":443"
+		}
+	case "http":
+		if p.endpoint.Port() == "" {
+			p.endpoint.Host = p.endpoint.Hostname() + ":80"
+		}
+	case "socks5", "socks5h":
+		if p.endpoint.Port() == "" {
+			p.endpoint.Host = p.endpoint.Hostname() + ":1080"
+		}
+	default:
+		return fmt.Errorf("invalid proxy scheme: %s", p.endpoint.Scheme)
+	}
+
+	return nil
+}
+
+func (p *proxy) isSOCKS5() bool {
+	return p.endpoint != nil && (p.endpoint.Scheme == "socks5" || p.endpoint.Scheme == "socks5h")
+}
+
+// initTargets build the target list from the Builder configuration
+func (u *Upstream) initTargets() error {
+	if len(u.targets.config) == 0 {
+		return fmt.Errorf("invalid target")
+	}
+	switch u.targets.policy {
+	case PolicyFailover, PolicyActiveStandby, PolicyRoundRobin:
+	default:
+		return fmt.Errorf("invalid target policy")
+	}
+	if u.targets.failBack <= 0 {
+		u.targets.failBack = defaultFailBack
+	}
+
+	u.targets.list = nil
+	for _, cfg := range u.targets.config {
+		if cfg.Addr == "" {
+			return fmt.Errorf("invalid target")
+		}
+
+		t := &target{addr: cfg.Addr, proxy: u.proxy}
+		if cfg.Proxy != nil {
+			t.proxy = proxy{endpoint: cfg.Proxy, caSum: cfg.ProxyCASum}
+		}
+		if err := t.proxy.init(); err != nil {
+			return fmt.Errorf("target %s: %s", t.addr, err.Error())
+		}
+		if u.targetTLS.enabled {
+			host, _, err := net.SplitHostPort(t.addr)
+			if err != nil {
+				return fmt.Errorf("target %s: %s", t.addr, err.Error())
+			}
+			t.tlsConfig = u.targetTLSConfig(host)
+		}
+
+		u.targets.list = append(u.targets.list, t)
+	}
+
+	return nil
+}
+
+// targetTLSConfig return tls config for target with the given host
+func (u *Upstream) targetTLSConfig(host string) *tls.Config {
+	var tlsConfig *tls.Config
+	if u.targetTLS.config != nil {
+		tlsConfig = u.targetTLS.config.Clone()
+	} else {
+		tlsConfig = maintls.TLSConfig()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+	if u.targetTLS.caSum != "" {
+		maintls.SetStaticPeerVerification(tlsConfig, true, u.targetTLS.caSum)
+	}
+	return tlsConfig
+}
+
+// pickTarget return the index of the target for the next connection attempt
+func (u *Upstream) pickTarget() int {
+	if u.targets.policy == PolicyRoundRobin {
+		return int((atomic.AddUint32(&u.targets.next, 1) - 1) % uint32(len(u.targets.list)))
+	}
+	return int(atomic.LoadInt32(&u.targets.current))
+}
+
+// targetFailed is called when connection to target i failed before it is ready,
+// the next target is used for the next connection attempt
+func (u *Upstream) targetFailed(i int) {
+	if u.targets.policy == PolicyRoundRobin || len(u.targets.list) == 1 {
+		return
+	}
+	next := (i + 1) % len(u.targets.list)
+	if atomic.CompareAndSwapInt32(&u.targets.current, int32(i), int32(next)) {
+		u.logErr("switching target from %s to %s", u.targets.list[i].addr, u.targets.list[next].addr)
+	}
+}
+
+// failBack probe the targets with higher priority than i every fail back period,
+// it returns error (so the connection is dropped and reconnected) when one of them can be connected
+func (u *Upstream) failBack(ctx context.Context, c *connection, i int) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(u.targets.failBack):
+		}
+
+		// another connection already moved back
+		back := int(atomic.LoadInt32(&u.targets.current))
+		if back >= i {
+			back = -1
+			for j := 0; j < i; j++ {
+				if u.probe(ctx, u.targets.list[j]) {
+					back = j
+					break
+				}
+			}
+			if back < 0 {
+				continue
+			}
+			atomic.CompareAndSwapInt32(&u.targets.current, int32(i), int32(back))
+		}
+
+		u.logInfo("%sfailing back to %s", c.prefix, u.targets.list[back].addr)
+		u.drain(ctx, c)
+
+		return fmt.Errorf("failing back to %s", u.targets.list[back].addr)
+	}
+}
+
+// probe return true if t can be connected
+func (u *Upstream) probe(ctx context.Context, t *target) bool {
+	conn, _, err := u.dialTarget(ctx, t)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// drain stop dispatching new request to c, and wait until requests that already dispatched to it are responded,
+// then sign off c if configured
+func (u *Upstream) drain(ctx context.Context, c *connection) {
+	u.pool.remove(c)
+
+	timeout := time.After(defaultNetworkMgmtTimeout)
+	for c.inFlightCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	if u.netMgmt.signOff != nil {
+		if err := u.runNetworkMgmt(ctx, c, u.netMgmt.signOff, u.netMgmt.signOffTimeout); err != nil {
+			u.logErr("%ssign off failed: %s", c.prefix, err.Error())
+		}
+	}
+}
+
+// ConnectedTargets return the address of the target of every connected (and signed on) connection.
+func (u *Upstream) ConnectedTargets() []string {
+	var ret []string
+	for _, c := range u.pool.list() {
+		ret = append(ret, c.target.addr)
+	}
+	return ret
+}
@@ -0,0 +1,87 @@
+package upstream_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func buildTargets(t *testing.T, policy upstream.TargetPolicy, poolSize int, addrs ...string) *upstream.Upstream {
+	var targets []upstream.Target
+	for _, addr := range addrs {
+		targets = append(targets, upstream.Target{Addr: addr})
+	}
+	return build(t, upstream.NewBuilder().
+		WithTargets(policy, targets...).
+		WithSpec(&spectest.RefSpec{}).
+		WithPool(poolSize, upstream.BalanceRoundRobin).
+		WithBackoff(upstream.ConstantBackoff(20*time.Millisecond), 0).
+		WithFailBack(100*time.Millisecond))
+}
+
+func connectedTo(u *upstream.Upstream, addrs ...string) func() bool {
+	return func() bool {
+		connected := u.ConnectedTargets()
+		sort.Strings(connected)
+		sort.Strings(addrs)
+		return reflect.DeepEqual(connected, addrs)
+	}
+}
+
+func TestTargetFailover(t *testing.T) {
+	primary := closedHost(t)
+	backup := newTestHost(t, nil, approve)
+	u := buildTargets(t, upstream.PolicyFailover, 1, primary, backup.addr)
+
+	waitFor(t, "connected to backup", connectedTo(u, backup.addr))
+	if _, err := process(u, request("000001")); err != nil {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+
+	// fail back to primary when it is up again
+	newTestHostAt(t, primary, nil, approve)
+	waitFor(t, "failing back to primary", connectedTo(u, primary))
+	if _, err := process(u, request("000002")); err != nil {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+}
+
+func TestTargetActiveStandby(t *testing.T) {
+	active := closedHost(t)
+	standby := newTestHost(t, nil, approve)
+	u := buildTargets(t, upstream.PolicyActiveStandby, 1, active, standby.addr)
+
+	waitFor(t, "connected to standby", connectedTo(u, standby.addr))
+
+	// no fail back, stay on standby while it is up
+	newTestHostAt(t, active, nil, approve)
+	time.Sleep(500 * time.Millisecond)
+	if !connectedTo(u, standby.addr)() {
+		t.Fatalf("invalid connected targets: %v, must stay on standby", u.ConnectedTargets())
+	}
+
+	standby.close()
+	waitFor(t, "connected to active", connectedTo(u, active))
+	if _, err := process(u, request("000001")); err != nil {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+}
+
+func TestTargetRoundRobin(t *testing.T) {
+	a := newTestHost(t, nil, approve)
+	b := newTestHost(t, nil, approve)
+	u := buildTargets(t, upstream.PolicyRoundRobin, 4, a.addr, b.addr)
+	waitFor(t, "connections spread to both targets", connectedTo(u, a.addr, a.addr, b.addr, b.addr))
+
+	// connections to closed target are moved to the other one
+	a.close()
+	waitFor(t, "connected to the remaining target", connectedTo(u, b.addr, b.addr, b.addr, b.addr))
+
+	closed := closedHost(t)
+	u = buildTargets(t, upstream.PolicyRoundRobin, 2, closed, b.addr)
+	waitFor(t, "connected to the open target", connectedTo(u, b.addr, b.addr))
+}
@@ -0,0 +1,121 @@
+package upstream_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+// silentHost accept connections and never write or close them
+func silentHost(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("invalid listen: %s", err.Error())
+	}
+
+	var lock sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		ln.Close()
+		lock.Lock()
+		defer lock.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			conns = append(conns, conn)
+			lock.Unlock()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestTimeouts(t *testing.T) {
+	blockOnNewConn := spec.Decorate(&spectest.RefSpec{}, spec.Hooks{
+		OnNewConn: func(ctx context.Context, conn net.Conn, readed []byte, next spec.OnNewConnFunc) ([]byte, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	silent := func(*hostConn, spec.Msg) spec.Msg { return nil }
+
+	for _, tc := range []struct {
+		name     string
+		build    func(b *upstream.Builder) *upstream.Builder
+		process  bool
+		expected string
+	}{
+		{
+			name: "dial",
+			build: func(b *upstream.Builder) *upstream.Builder {
+				return b.WithTarget(silentHost(t)).WithSpec(&spectest.RefSpec{}).
+					WithTLS(nil, "").
+					WithDialTimeout(100 * time.Millisecond)
+			},
+			expected: "TLS handshake failed: timeout",
+		},
+		{
+			name: "OnNewConn",
+			build: func(b *upstream.Builder) *upstream.Builder {
+				return b.WithTarget(silentHost(t)).WithSpec(blockOnNewConn).
+					WithOnNewConnTimeout(100 * time.Millisecond)
+			},
+			expected: "OnNewConn failed: timeout",
+		},
+		{
+			name: "ping",
+			build: func(b *upstream.Builder) *upstream.Builder {
+				return b.WithTarget(newTestHost(t, nil, silent).addr).
+					WithSpec(&spectest.RefSpec{PingInterval: 100 * time.Millisecond}).
+					WithMinPingInterval(100 * time.Millisecond)
+			},
+			expected: "inactive for 100ms",
+		},
+		{
+			name: "write",
+			build: func(b *upstream.Builder) *upstream.Builder {
+				return b.WithTarget(newTestHost(t, nil, approve).addr).WithSpec(&spectest.RefSpec{}).
+					WithWriteTimeout(time.Nanosecond)
+			},
+			process:  true,
+			expected: "write error",
+		},
+		{
+			name: "read",
+			build: func(b *upstream.Builder) *upstream.Builder {
+				return b.WithTarget(newTestHost(t, nil, silent).addr).WithSpec(&spectest.RefSpec{}).
+					WithReadTimeout(100 * time.Millisecond)
+			},
+			expected: "read error",
+		},
+	} {
+		l := newTestLogger(t)
+		u := build(t, tc.build(upstream.NewBuilder().
+			WithLogger(l.info, l.err).
+			WithBackoff(upstream.ConstantBackoff(time.Hour), 0)))
+
+		if tc.process {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			u.Process(ctx, request("000001"))
+			cancel()
+		}
+
+		waitFor(t, tc.name+" timeout", func() bool { return l.hasErr(tc.expected) })
+		u.Close()
+	}
+}
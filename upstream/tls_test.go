@@ -0,0 +1,159 @@
+package upstream_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+// testCA issue certificates for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("invalid GenerateKey: %s", err.Error())
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("invalid CreateCertificate: %s", err.Error())
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue certificate for 127.0.0.1 usable for both server and client auth
+func (ca *testCA) issue(t *testing.T, serial int64) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("invalid GenerateKey: %s", err.Error())
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("invalid CreateCertificate: %s", err.Error())
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveTLSHost serve RefSpec host that approve every request over TLS, client certificate issued by ca is required
+func serveTLSHost(t *testing.T, ca *testCA) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, 2)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	})
+	if err != nil {
+		t.Fatalf("invalid listen: %s", err.Error())
+	}
+	return newTestHostListener(t, ln, nil, approve).addr
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	addr := serveTLSHost(t, ca)
+	client := ca.issue(t, 3)
+
+	for _, tc := range []struct {
+		name     string
+		config   *tls.Config
+		expected string
+	}{
+		{"valid", &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{client}}, ""},
+		{"wrong CA", &tls.Config{RootCAs: newTestCA(t).pool, Certificates: []tls.Certificate{client}}, "TLS handshake failed"},
+		{"missing client certificate", &tls.Config{RootCAs: ca.pool}, "certificate required"},
+	} {
+		l := newTestLogger(t)
+		u := build(t, upstream.NewBuilder().
+			WithTarget(addr).
+			WithSpec(&spectest.RefSpec{}).
+			WithLogger(l.info, l.err).
+			WithBackoff(upstream.ConstantBackoff(time.Hour), 0).
+			WithTLS(tc.config, ""))
+
+		if tc.expected == "" {
+			if _, err := process(u, request("000001")); err != nil {
+				t.Fatalf("%s: invalid process: %s", tc.name, err.Error())
+			}
+		} else {
+			waitFor(t, tc.name+" error", func() bool { return l.hasErr(tc.expected) })
+			if len(u.ConnectedTargets()) != 0 {
+				t.Fatalf("%s: must not be connected", tc.name)
+			}
+		}
+		u.Close()
+	}
+}
+
+func TestTLSFiles(t *testing.T) {
+	ca := newTestCA(t)
+	addr := serveTLSHost(t, ca)
+	client := ca.issue(t, 3)
+
+	dir := t.TempDir()
+	keyDER, err := x509.MarshalECPrivateKey(client.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("invalid MarshalECPrivateKey: %s", err.Error())
+	}
+	for name, block := range map[string]*pem.Block{
+		"client.crt": {Type: "CERTIFICATE", Bytes: client.Certificate[0]},
+		"client.key": {Type: "EC PRIVATE KEY", Bytes: keyDER},
+		"ca.crt":     {Type: "CERTIFICATE", Bytes: ca.cert.Raw},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("invalid WriteFile: %s", err.Error())
+		}
+	}
+
+	u := build(t, upstream.NewBuilder().
+		WithTarget(addr).
+		WithSpec(&spectest.RefSpec{}).
+		WithTLSFiles(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt"), ""))
+	if _, err := process(u, request("000001")); err != nil {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+
+	if _, err := upstream.NewBuilder().
+		WithTarget(addr).
+		WithSpec(&spectest.RefSpec{}).
+		WithTLSFiles(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "client.key"), "", "").
+		Build(); err == nil {
+		t.Fatalf("WithTLSFiles with missing certificate must fail")
+	}
+}
@@ -9,13 +9,12 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/payfazz/iso8585-utility-lib/upstream/seq"
 	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
-	"github.com/payfazz/mainutil/maintls"
 )
 
 // Upstream .
@@ -24,74 +23,140 @@ type Upstream struct {
 	cancelLifetimeCtx context.CancelFunc
 	wait              sync.WaitGroup
 
-	target string
 	spec   spec.Spec
 	logger struct {
 		Info func(string)
 		Err  func(string)
 	}
 
-	proxy struct {
-		endpoint  *url.URL
-		tlsConfig *tls.Config
-		caSum     string
+	targets struct {
+		config   []Target
+		policy   TargetPolicy
+		failBack time.Duration
+
+		list    []*target
+		current int32
+		next    uint32
+	}
+
+	// proxy is the default proxy for targets without its own proxy
+	proxy proxy
+
+	targetTLS struct {
+		enabled bool
+		config  *tls.Config
+		caSum   string
+	}
+
+	stan *seq.STAN
+
+	netMgmt struct {
+		// stan fill field 11 of network management messages, it is stan or in-memory STAN if stan is not set
+		stan *seq.STAN
+
+		signOn        NetworkMgmtFunc
+		signOnTimeout time.Duration
+
+		signOff        NetworkMgmtFunc
+		signOffTimeout time.Duration
+
+		echo         NetworkMgmtFunc
+		echoInterval time.Duration
+
+		onCutover func(msg spec.Msg)
+	}
+
+	keyExchange struct {
+		fn      KeyExchangeFunc
+		timeout time.Duration
+
+		lock sync.RWMutex
+		keys *spec.WorkingKeys
+		from *connection
+
+		// specLock guard the working keys of the spec, see withKeys
+		specLock sync.Mutex
+		specKeys *spec.WorkingKeys
 	}
 
+	readLimits spec.Limits
+
+	timeouts struct {
+		dial      time.Duration
+		onNewConn time.Duration
+		minPing   time.Duration
+		write     time.Duration
+		read      time.Duration
+	}
+
+	pool pool
+
+	reconnect struct {
+		backoff Backoff
+		stable  time.Duration
+	}
+
+	breaker breaker
+
 	submission struct {
 		data struct {
 			lock sync.RWMutex
 			data map[string]*submission
 		}
-
-		priorityNotify chan *submission
-		notify         chan *submission
 	}
-
-	lastActive int64
 }
 
 // Build .
 func (b Builder) Build() (*Upstream, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
 	u := b.inner
-	if u.target == "" {
-		return nil, fmt.Errorf("invalid target")
+	if err := u.initTargets(); err != nil {
+		return nil, err
 	}
 	if u.spec == nil {
 		return nil, fmt.Errorf("invalid spec")
 	}
-	if u.proxy.endpoint != nil {
-		u.proxy.endpoint.Scheme = strings.ToLower(u.proxy.endpoint.Scheme)
-		switch u.proxy.endpoint.Scheme {
-		case "https":
-			tlsConfig := maintls.TLSConfig()
-			tlsConfig.ServerName = u.proxy.endpoint.Hostname()
-			if u.proxy.caSum != "" {
-				maintls.SetStaticPeerVerification(tlsConfig, true, u.proxy.caSum)
-			}
-			u.proxy.tlsConfig = tlsConfig
-			if u.proxy.endpoint.Port() == "" {
-				u.proxy.endpoint.Host = u.proxy.endpoint.Hostname() + ":443"
-			}
-		case "http":
-			if u.proxy.endpoint.Port() == "" {
-				u.proxy.endpoint.Host = u.proxy.endpoint.Hostname() + ":80"
-			}
-		default:
-			return nil, fmt.Errorf("invalid proxy scheme: %s", u.proxy.endpoint.Scheme)
-		}
+	if u.netMgmt.echo != nil && u.netMgmt.echoInterval <= 0 {
+		return nil, fmt.Errorf("invalid echo interval")
+	}
+	u.netMgmt.stan = u.stan
+	if u.netMgmt.stan == nil {
+		u.netMgmt.stan = seq.NewSTAN(nil)
+	}
+	if err := u.initTimeouts(); err != nil {
+		return nil, err
+	}
+	if u.reconnect.backoff == nil {
+		u.reconnect.backoff = ConstantBackoff(defaultBackoffDelay)
+	}
+	if u.reconnect.stable <= 0 {
+		u.reconnect.stable = defaultBackoffStable
+	}
+	if u.breaker.threshold < 0 {
+		return nil, fmt.Errorf("invalid circuit breaker threshold")
+	}
+	if u.pool.size < 0 {
+		return nil, fmt.Errorf("invalid pool size")
+	}
+	if u.pool.balance != BalanceRoundRobin && u.pool.balance != BalanceLeastInFlight {
+		return nil, fmt.Errorf("invalid pool balance")
 	}
-
 	u.lifetimeCtx, u.cancelLifetimeCtx = context.WithCancel(context.Background())
 
 	u.submission.data.data = make(map[string]*submission)
-	u.submission.priorityNotify = make(chan *submission)
-	u.submission.notify = make(chan *submission)
+	u.pool.init()
 
-	u.wait.Add(1)
-	go func() {
-		defer u.wait.Done()
-		u.main()
-	}()
+	for i := 0; i < u.pool.size; i++ {
+		slot := i
+		u.wait.Add(1)
+		go func() {
+			defer u.wait.Done()
+			u.main(slot)
+		}()
+	}
 
 	return u, nil
 }
@@ -102,152 +167,232 @@ func (u *Upstream) Close() error {
 		return nil
 	}
 
+	u.signOff()
+
 	u.cancelLifetimeCtx()
 	u.wait.Wait()
 
 	return nil
 }
 
-func (u *Upstream) main() {
-	processUpstreamConnection := func() error {
-		var wait sync.WaitGroup
-		defer wait.Wait()
+func (u *Upstream) main(slot int) {
+	attempt := 0
+	for {
+		t := u.pickTarget()
+		readyAt, err := u.processUpstreamConnection(slot, t)
+
+		if u.isClosed() {
+			return
+		}
 
-		ctx, cancelCtx := context.WithCancel(u.lifetimeCtx)
-		defer cancelCtx()
+		if !readyAt.IsZero() && time.Since(readyAt) >= u.reconnect.stable {
+			attempt = 0
+		}
+		attempt++
+		delay := u.reconnect.backoff(attempt)
 
-		conn, unprocessed, err := u.dial(ctx)
 		if err != nil {
-			return err
+			if err == context.DeadlineExceeded {
+				err = fmt.Errorf("timeout")
+			}
+			u.logErr("%sconnection error (reconnect in %s): %s", u.connPrefix(slot), delay.String(), err.Error())
+			if readyAt.IsZero() {
+				u.targetFailed(t)
+				if u.breaker.failed(err) {
+					u.logErr("circuit breaker opened")
+					u.pool.wake()
+				}
+			}
 		}
 
-		defer func() {
-			u.logInfo("closing connection")
-			conn.Close()
-		}()
+		select {
+		case <-u.lifetimeCtx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
 
-		u.logInfo("connected")
+// processUpstreamConnection connect to target ti and process it until error,
+// readyAt is the time the connection is ready (signed on), zero if it is not ready before the error
+func (u *Upstream) processUpstreamConnection(slot int, ti int) (readyAt time.Time, err error) {
+	ctx, cancelCtx := context.WithCancel(u.lifetimeCtx)
 
-		atomic.StoreInt64(&u.lastActive, time.Now().Unix())
+	c := newConnection(ctx, u.connPrefix(slot), u.targets.list[ti])
 
-		errCh := make(chan error, 1)
-		passErr := func(err error) {
-			if err != nil {
-				select {
-				case errCh <- err:
-				default:
-				}
-			}
-		}
+	// requests written to this connection that still waiting for response is sent to another connection,
+	// after reader and writer exited
+	defer u.redispatch(c)
 
-		// r-lock submission data, so no new submission is submited
-		u.submission.data.lock.RLock()
+	defer u.setWorkingKeys(c, nil)
 
-		// snapshot submission
-		var snapshot []*submission
-		for _, v := range u.submission.data.data {
-			snapshot = append(snapshot, v)
-		}
+	var wait sync.WaitGroup
+	defer wait.Wait()
 
-		prioritized := make(map[*submission]struct{})
-
-		// flush notification when holding r-lock to submission data,
-		// to avoid duplicate notification on new writer
-		// NOTE: need to wrap in function call, so RUnlock() after it always called
-		func() {
-			for {
-				select {
-				case <-u.submission.notify:
-				case s := <-u.submission.priorityNotify:
-					prioritized[s] = struct{}{}
-				case <-ctx.Done():
-					return
-				default:
-					return
-				}
-			}
-		}()
+	defer cancelCtx()
 
-		// unlock submission data,
-		// snapshoted submission is now clear from pending notification
-		u.submission.data.lock.RUnlock()
+	conn, unprocessed, err := u.dial(ctx, c.target)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if u.timeouts.read > 0 {
+		conn = &readDeadlineConn{Conn: conn, timeout: u.timeouts.read}
+	}
 
-		wait.Add(1)
-		go func() {
-			defer wait.Done()
+	defer func() {
+		u.logInfo("%sclosing connection", c.prefix)
+		conn.Close()
+	}()
 
-			// renotify all snapshoted submission
-			for _, v := range snapshot {
-				if _, ok := prioritized[v]; ok {
-					select {
-					case <-ctx.Done():
-						return
-					case u.submission.priorityNotify <- v:
-					}
-				} else {
-					select {
-					case <-ctx.Done():
-						return
-					case u.submission.notify <- v:
-					}
-				}
+	u.logInfo("%sconnected to %s", c.prefix, c.target.addr)
+
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+
+	errCh := make(chan error, 1)
+	passErr := func(err error) {
+		if err != nil {
+			select {
+			case errCh <- err:
+			default:
 			}
-		}()
+		}
+	}
+
+	// writer will only write prioritized submission until readyCh is closed,
+	// so network management (sign on) can be done before any other submission is written
+	readyCh := make(chan struct{})
 
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		passErr(u.reader(ctx, c, conn, unprocessed))
+	}()
+
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		passErr(u.writer(ctx, c, conn, readyCh))
+	}()
+
+	// runBeforeReady run fn while watching error from reader and writer
+	runBeforeReady := func(fn func() error) error {
+		fnErrCh := make(chan error, 1)
 		wait.Add(1)
 		go func() {
 			defer wait.Done()
-			passErr(u.reader(ctx, conn, unprocessed))
+			fnErrCh <- fn()
 		}()
 
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errCh:
+			return err
+		case err := <-fnErrCh:
+			return err
+		}
+	}
+
+	if u.netMgmt.signOn != nil {
+		err := runBeforeReady(func() error {
+			return u.runNetworkMgmt(ctx, c, u.netMgmt.signOn, u.netMgmt.signOnTimeout)
+		})
+		if err != nil {
+			return time.Time{}, fmt.Errorf("sign on failed: %s", err.Error())
+		}
+		u.logInfo("%ssigned on", c.prefix)
+	}
+
+	if u.keyExchange.fn != nil {
+		err := runBeforeReady(func() error {
+			return u.exchangeKeys(ctx, c, nil)
+		})
+		if err != nil {
+			return time.Time{}, fmt.Errorf("key exchange failed: %s", err.Error())
+		}
+	}
+
+	atomic.StoreInt32(&c.signedOn, 1)
+	defer atomic.StoreInt32(&c.signedOn, 0)
+
+	close(readyCh)
+
+	readyAt = time.Now()
+	if u.breaker.succeeded() {
+		u.logInfo("circuit breaker closed")
+	}
+
+	u.pool.add(c)
+	defer u.pool.remove(c)
+
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		passErr(u.pinger(ctx, c))
+	}()
+
+	if u.netMgmt.echo != nil {
 		wait.Add(1)
 		go func() {
 			defer wait.Done()
-			passErr(u.writer(ctx, conn))
+			passErr(u.echo(ctx, c))
 		}()
+	}
 
+	if u.targets.policy == PolicyFailover && ti > 0 {
 		wait.Add(1)
 		go func() {
 			defer wait.Done()
-			passErr(u.pinger(ctx))
+			passErr(u.failBack(ctx, c, ti))
 		}()
+	}
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case err := <-errCh:
-			return err
-		}
+	select {
+	case <-ctx.Done():
+		return readyAt, ctx.Err()
+	case err := <-errCh:
+		return readyAt, err
 	}
+}
 
-	for {
-		err := processUpstreamConnection()
+// connPrefix return log prefix of connection in slot, empty if pool size is 1
+func (u *Upstream) connPrefix(slot int) string {
+	if u.pool.size <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("conn %d: ", slot+1)
+}
 
-		if u.isClosed() {
-			return
-		}
+// dial connect to t and run OnNewConn of the spec
+func (u *Upstream) dial(ctx context.Context, t *target) (net.Conn, []byte, error) {
+	conn, unprocessedRead, err := u.dialTarget(ctx, t)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		if err != nil {
-			if err == context.DeadlineExceeded {
-				err = fmt.Errorf("timeout")
-			}
-			u.logErr("connection error (reconnect in 0.5s): %s", err.Error())
-			select {
-			case <-u.lifetimeCtx.Done():
-				return
-			case <-time.After(500 * time.Millisecond):
-			}
+	onNewConnCtx, cancelonNewConnCtx := context.WithTimeout(ctx, u.timeouts.onNewConn)
+	defer cancelonNewConnCtx()
+
+	unprocessedRead, err = u.spec.OnNewConn(onNewConnCtx, conn, unprocessedRead)
+	if err != nil {
+		conn.Close()
+		if err == context.DeadlineExceeded {
+			err = fmt.Errorf("timeout")
 		}
+		return nil, nil, fmt.Errorf("OnNewConn failed: %s", err.Error())
 	}
+
+	return conn, unprocessedRead, nil
 }
 
-func (u *Upstream) dial(ctx context.Context) (net.Conn, []byte, error) {
-	dialCtx, cancelDialCtx := context.WithTimeout(ctx, 5*time.Second)
+// dialTarget open connection to t, through its proxy if any
+func (u *Upstream) dialTarget(ctx context.Context, t *target) (net.Conn, []byte, error) {
+	dialCtx, cancelDialCtx := context.WithTimeout(ctx, u.timeouts.dial)
 	defer cancelDialCtx()
 
-	dialTarget := u.target
-	if u.proxy.endpoint != nil {
-		dialTarget = u.proxy.endpoint.Host
+	dialTarget := t.addr
+	if t.proxy.endpoint != nil {
+		dialTarget = t.proxy.endpoint.Host
 	}
 	conn, err := netDialer.DialContext(dialCtx, "tcp", dialTarget)
 	if err != nil {
@@ -257,20 +402,25 @@ func (u *Upstream) dial(ctx context.Context) (net.Conn, []byte, error) {
 
 	var unprocessedRead []byte
 
-	if u.proxy.endpoint != nil {
-		if u.proxy.endpoint.Scheme == "https" {
-			conn = tls.Client(conn, u.proxy.tlsConfig)
+	if t.proxy.isSOCKS5() {
+		if err := socks5Connect(dialCtx, conn, t); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	} else if t.proxy.endpoint != nil {
+		if t.proxy.endpoint.Scheme == "https" {
+			conn = tls.Client(conn, t.proxy.tlsConfig)
 		}
 
 		req := &http.Request{
 			Method: http.MethodConnect,
-			URL:    &url.URL{Opaque: u.target},
+			URL:    &url.URL{Opaque: t.addr},
 			Header: http.Header{
-				"Host": []string{u.target},
+				"Host": []string{t.addr},
 			},
 		}
-		user := u.proxy.endpoint.User.Username()
-		pass, _ := u.proxy.endpoint.User.Password()
+		user := t.proxy.endpoint.User.Username()
+		pass, _ := t.proxy.endpoint.User.Password()
 		if user != "" || pass != "" {
 			req.Header["Proxy-Authorization"] = []string{
 				"Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass)),
@@ -328,16 +478,21 @@ func (u *Upstream) dial(ctx context.Context) (net.Conn, []byte, error) {
 		}
 	}
 
-	onNewConnCtx, cancelonNewConnCtx := context.WithTimeout(ctx, 10*time.Second)
-	defer cancelonNewConnCtx()
+	if t.tlsConfig != nil {
+		if len(unprocessedRead) > 0 {
+			conn.Close()
+			return nil, nil, fmt.Errorf("unexpected data before TLS handshake")
+		}
 
-	unprocessedRead, err = u.spec.OnNewConn(onNewConnCtx, conn, unprocessedRead)
-	if err != nil {
-		conn.Close()
-		if err == context.DeadlineExceeded {
-			err = fmt.Errorf("timeout")
+		tlsConn := tls.Client(conn, t.tlsConfig)
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			conn.Close()
+			if dialCtx.Err() != nil {
+				err = fmt.Errorf("timeout")
+			}
+			return nil, nil, fmt.Errorf("TLS handshake failed: %s", err.Error())
 		}
-		return nil, nil, fmt.Errorf("OnNewConn failed: %s", err.Error())
+		conn = tlsConn
 	}
 
 	return conn, unprocessedRead, nil
@@ -345,12 +500,30 @@ func (u *Upstream) dial(ctx context.Context) (net.Conn, []byte, error) {
 
 // Process .
 func (u *Upstream) Process(ctx context.Context, msg spec.Msg) (res spec.Msg, err error) {
-	msgRaw, err := u.spec.MsgEncode(msg)
+	return u.process(ctx, msg, nil)
+}
+
+// process is Process, but if c is not nil, msg is sent through c with priority
+func (u *Upstream) process(ctx context.Context, msg spec.Msg, c *connection) (res spec.Msg, err error) {
+	gen := u.stan
+	if c != nil {
+		gen = u.netMgmt.stan
+	}
+	if _, ok := msg[11]; !ok && gen != nil {
+		msg, err = u.assignSTAN(gen, msg)
+		if err != nil {
+			return nil, &ErrInvalidRequest{Cause: err}
+		}
+	}
+
+	// msg is prepared once, so it keep the stamped fields when it is sent again (see redispatch),
+	// only the encoding is done by the writer, with the working keys of the connection (see encodePrepared)
+	prepared, err := u.prepare(msg)
 	if err != nil {
 		return nil, &ErrInvalidRequest{Cause: err}
 	}
 
-	s := newSubmission(u.spec.MsgID(msg), msg, msgRaw, false)
+	s := newSubmission(u.spec.MsgID(prepared), prepared, false)
 
 	u.submission.data.lock.Lock()
 	_, duplicate := u.submission.data.data[s.id]
@@ -363,35 +536,70 @@ func (u *Upstream) Process(ctx context.Context, msg spec.Msg) (res spec.Msg, err
 		return nil, &ErrInvalidRequest{Cause: fmt.Errorf("duplicate ongoing request")}
 	}
 
-	removeSubmission := func(err error) {
-		s.setErr(err)
-		u.submission.data.lock.Lock()
-		delete(u.submission.data.data, s.id)
-		u.submission.data.lock.Unlock()
-	}
+	// connDone is nil (blocking forever) for normal request, it can be sent through another connection
+	var connDone <-chan struct{}
 
-	select {
-	case <-u.lifetimeCtx.Done():
-		removeSubmission(ErrServerClosed)
-		return nil, ErrServerClosed
+	if c == nil {
+		if err := u.dispatch(ctx, s); err != nil {
+			u.removeSubmission(s, err)
+			return nil, err
+		}
+	} else {
+		connDone = c.ctx.Done()
 
-	case <-ctx.Done():
-		removeSubmission(ctx.Err())
-		return nil, ctx.Err()
+		select {
+		case <-u.lifetimeCtx.Done():
+			u.removeSubmission(s, ErrServerClosed)
+			return nil, ErrServerClosed
+
+		case <-ctx.Done():
+			u.removeSubmission(s, ctx.Err())
+			return nil, ctx.Err()
+
+		case <-connDone:
+			u.removeSubmission(s, errConnClosed)
+			return nil, errConnClosed
 
-	case u.submission.notify <- s:
+		case c.priorityNotify <- s:
+		}
 	}
 
 	select {
 	case <-u.lifetimeCtx.Done():
-		removeSubmission(ErrServerClosed)
+		u.removeSubmission(s, ErrServerClosed)
 		return nil, ErrServerClosed
 
 	case <-ctx.Done():
-		removeSubmission(ctx.Err())
+		u.removeSubmission(s, ctx.Err())
 		return nil, ctx.Err()
 
+	case <-connDone:
+		u.removeSubmission(s, errConnClosed)
+		return nil, errConnClosed
+
 	case <-s.doneCh:
 		return s.recv.msg, s.err
 	}
 }
+
+// assignSTAN return copy of msg with field 11 filled, skipping STAN that collide with in-flight submission
+func (u *Upstream) assignSTAN(gen *seq.STAN, msg spec.Msg) (spec.Msg, error) {
+	msg = msg.Clone()
+
+	stan, err := gen.NextSkip(msg[41], func(stan string) bool {
+		msg[11] = stan
+		id := u.spec.MsgID(msg)
+
+		u.submission.data.lock.RLock()
+		_, inFlight := u.submission.data.data[id]
+		u.submission.data.lock.RUnlock()
+
+		return inFlight
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate STAN: %s", err.Error())
+	}
+
+	msg[11] = stan
+	return msg, nil
+}
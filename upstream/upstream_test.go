@@ -0,0 +1,191 @@
+package upstream_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/payfazz/iso8585-utility-lib/upstream"
+	"github.com/payfazz/iso8585-utility-lib/upstream/seq"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
+	"github.com/payfazz/iso8585-utility-lib/upstream/spec/spectest"
+)
+
+func TestProcessStamp(t *testing.T) {
+	h := newTestHost(t, nil, approve)
+
+	var stan int32
+	s := spec.Decorate(&spectest.RefSpec{}, spec.Stamp(11, func(spec.Msg) (string, error) {
+		return fmt.Sprintf("%06d", atomic.AddInt32(&stan, 1)), nil
+	}))
+	u := build(t, upstream.NewBuilder().WithTarget(h.addr).WithSpec(s).WithPool(2, upstream.BalanceRoundRobin))
+
+	var wait sync.WaitGroup
+	var lock sync.Mutex
+	seen := make(map[string]bool)
+	errCh := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+
+			res, err := process(u, spec.Msg{0: "0200", 3: "000000", 41: "TERM0001"})
+			if err != nil {
+				errCh <- err
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if res[11] == "" || seen[res[11]] {
+				errCh <- fmt.Errorf("invalid response STAN: %q", res[11])
+			}
+			seen[res[11]] = true
+		}()
+	}
+	wait.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+}
+
+func TestProcessStampRedispatch(t *testing.T) {
+	// the first connection is closed by the host without responding, the request is sent again with the same STAN
+	var lock sync.Mutex
+	var received []string
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		if msg[0] != "0200" {
+			return approve(hc, msg)
+		}
+		lock.Lock()
+		received = append(received, msg[11])
+		lock.Unlock()
+		if hc.index == 0 {
+			hc.close()
+			return nil
+		}
+		return approve(hc, msg)
+	})
+
+	var stan int32
+	s := spec.Decorate(&spectest.RefSpec{}, spec.Stamp(11, func(spec.Msg) (string, error) {
+		return fmt.Sprintf("%06d", atomic.AddInt32(&stan, 1)), nil
+	}))
+	u := build(t, upstream.NewBuilder().WithTarget(h.addr).WithSpec(s).WithPool(2, upstream.BalanceRoundRobin))
+	waitFor(t, "pool connected", func() bool { return len(u.ConnectedTargets()) == 2 })
+
+	res, err := process(u, spec.Msg{0: "0200", 3: "000000", 41: "TERM0001"})
+	if err != nil {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if res[11] != "000001" || atomic.LoadInt32(&stan) != 1 {
+		t.Fatalf("invalid response: %v, stamped %d times", res, atomic.LoadInt32(&stan))
+	}
+	for _, v := range received {
+		if v != "000001" {
+			t.Fatalf("invalid STAN received by the host: %v", received)
+		}
+	}
+}
+
+func TestProcessDuplicate(t *testing.T) {
+	release := make(chan struct{})
+	var received int32
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		if msg[0] == "0200" {
+			atomic.AddInt32(&received, 1)
+			<-release
+		}
+		return approve(hc, msg)
+	})
+	defer close(release)
+
+	u := build(t, upstream.NewBuilder().WithTarget(h.addr).WithSpec(&spectest.RefSpec{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go u.Process(ctx, request("000001"))
+
+	waitFor(t, "first request received", func() bool { return atomic.LoadInt32(&received) == 1 })
+	_, err := process(u, request("000001"))
+	var invalid *upstream.ErrInvalidRequest
+	if !errors.As(err, &invalid) || err.Error() != "duplicate ongoing request" {
+		t.Fatalf("invalid err: %v", err)
+	}
+}
+
+func TestProcessInvalidRequest(t *testing.T) {
+	// invalid and duplicate request is rejected without waiting for a connection
+	s := spec.Decorate(&spectest.RefSpec{}, spec.NewRules().Add("0200", spec.Mandatory(3)).Hooks())
+	u := build(t, upstream.NewBuilder().WithTarget(closedHost(t)).WithSpec(s))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go u.Process(ctx, request("000001"))
+	time.Sleep(50 * time.Millisecond)
+
+	for _, tc := range []struct {
+		msg      spec.Msg
+		expected string
+	}{
+		{spec.Msg{0: "0200", 11: "000002", 41: "TERM0001"}, "invalid 0200 message: field 3 is mandatory but missing"},
+		{request("000001"), "duplicate ongoing request"},
+	} {
+		start := time.Now()
+		_, err := process(u, tc.msg)
+		var invalid *upstream.ErrInvalidRequest
+		if !errors.As(err, &invalid) || err.Error() != tc.expected {
+			t.Fatalf("invalid err: %v", err)
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("invalid request must be rejected immediately")
+		}
+	}
+}
+
+func TestProcessSTAN(t *testing.T) {
+	release := make(chan struct{})
+	var received int32
+	h := newTestHost(t, nil, func(hc *hostConn, msg spec.Msg) spec.Msg {
+		if msg[0] == "0200" && msg[11] == "000001" && msg[41] == "TERM0001" {
+			atomic.AddInt32(&received, 1)
+			<-release
+		}
+		return approve(hc, msg)
+	})
+	defer close(release)
+
+	u := build(t, upstream.NewBuilder().WithTarget(h.addr).WithSpec(&spectest.RefSpec{}).WithSTAN(seq.NewSTAN(nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go u.Process(ctx, request("000001"))
+	waitFor(t, "first request received", func() bool { return atomic.LoadInt32(&received) == 1 })
+
+	for _, expected := range []string{"000002", "000003"} {
+		res, err := process(u, spec.Msg{0: "0200", 3: "000000", 41: "TERM0001"})
+		if err != nil {
+			t.Fatalf("invalid process: %s", err.Error())
+		}
+		if res[11] != expected {
+			t.Fatalf("invalid STAN: %s, expected %s", res[11], expected)
+		}
+	}
+
+	// STAN is independent per terminal
+	res, err := process(u, spec.Msg{0: "0200", 3: "000000", 41: "TERM0002"})
+	if err != nil {
+		t.Fatalf("invalid process: %s", err.Error())
+	}
+	if res[11] != "000001" {
+		t.Fatalf("invalid STAN: %s", res[11])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/payfazz/iso8585-utility-lib/upstream/spec"
 )
@@ -16,7 +17,6 @@ type submission struct {
 	sendOnly bool
 	send     struct {
 		msg spec.Msg
-		raw []byte
 	}
 
 	recv struct {
@@ -29,11 +29,10 @@ type submission struct {
 	doneCh chan struct{}
 }
 
-func newSubmission(id string, msg spec.Msg, msgRaw []byte, sendOnly bool) *submission {
+func newSubmission(id string, msg spec.Msg, sendOnly bool) *submission {
 	s := &submission{}
 	s.id = id
 	s.send.msg = msg
-	s.send.raw = msgRaw
 	s.sendOnly = sendOnly
 	s.doneCh = make(chan struct{})
 	return s
@@ -95,3 +94,16 @@ func (c *connCloserHelper) Close() error {
 	c.closer.Do(func() { c.err = c.Conn.Close() })
 	return c.err
 }
+
+// net.Conn wrapper that set read deadline before every read
+type readDeadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *readDeadlineConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
@@ -4,33 +4,45 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 )
 
-func (u *Upstream) writer(ctx context.Context, conn net.Conn) error {
+func (u *Upstream) writer(ctx context.Context, c *connection, conn net.Conn, ready <-chan struct{}) error {
 	write := func(s *submission) error {
 		if s.isDone() {
 			return nil
 		}
 
-		u.submission.data.lock.RLock()
-		_, ok := u.submission.data.data[s.id]
-		u.submission.data.lock.RUnlock()
-		if !ok {
+		msg := s.send.msg
+		var msgRaw []byte
+		var err error
+		if s.sendOnly {
+			msg, err = u.prepare(msg)
+		}
+		if err == nil {
+			msgRaw, err = u.encodePrepared(c, msg)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				// s is sent through another connection, see redispatch
+				return ctx.Err()
+			}
+			if s.sendOnly {
+				u.logErr("%scannot encode %s: %s", c.prefix, s.send.msg, err.Error())
+			}
+			u.removeSubmission(s, &ErrInvalidRequest{Cause: err})
 			return nil
 		}
 
-		u.logInfo("W: %s", s.send.msg)
+		u.logInfo("%sW: %s", c.prefix, msg)
 
-		_, err := conn.Write(s.send.raw)
+		err = conn.SetWriteDeadline(time.Now().Add(u.timeouts.write))
+		if err == nil {
+			_, err = conn.Write(msgRaw)
+		}
 
 		if s.sendOnly {
-			markSubmissionComplete := func() {
-				s.setOk(nil, nil)
-				u.submission.data.lock.Lock()
-				delete(u.submission.data.data, s.id)
-				u.submission.data.lock.Unlock()
-			}
-			go markSubmissionComplete()
+			s.setOk(nil, nil)
 		}
 
 		if err != nil {
@@ -40,9 +52,12 @@ func (u *Upstream) writer(ctx context.Context, conn net.Conn) error {
 		return nil
 	}
 
+	// notify is nil (blocking forever) until ready is closed
+	var notify chan *submission
+
 	for {
 		select {
-		case s := <-u.submission.priorityNotify:
+		case s := <-c.priorityNotify:
 			if err := write(s); err != nil {
 				return err
 			}
@@ -50,11 +65,14 @@ func (u *Upstream) writer(ctx context.Context, conn net.Conn) error {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case s := <-u.submission.notify:
+			case <-ready:
+				notify = c.notify
+				ready = nil
+			case s := <-notify:
 				if err := write(s); err != nil {
 					return err
 				}
-			case s := <-u.submission.priorityNotify:
+			case s := <-c.priorityNotify:
 				if err := write(s); err != nil {
 					return err
 				}